@@ -3,16 +3,80 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 )
 
+func usage() {
+	fmt.Printf("usage: 9cc [-E] [-I<dir>] [-fmax-errors=<n>] <file>...\n")
+	os.Exit(1)
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Printf("usage: 9cc <program>\n")
-		os.Exit(1)
+	var paths []string
+	preprocessOnly := false
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-E" {
+			preprocessOnly = true
+			continue
+		}
+		if arg == "-I" {
+			if i+1 >= len(args) {
+				usage()
+			}
+			i++
+			includePaths = append(includePaths, args[i])
+			continue
+		}
+		if strings.HasPrefix(arg, "-I") {
+			includePaths = append(includePaths, strings.TrimPrefix(arg, "-I"))
+			continue
+		}
+		if strings.HasPrefix(arg, "-fmax-errors=") {
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "-fmax-errors="))
+			if err != nil || n < 0 {
+				usage()
+			}
+			errorLimit = n
+			continue
+		}
+		if len(arg) > 1 && arg[0] == '-' {
+			usage()
+		}
+		paths = append(paths, arg)
+	}
+	if len(paths) == 0 {
+		usage()
+	}
+
+	// Each file is preprocessed and parsed separately as a translation unit.
+	var tokens []*Token
+	for _, path := range paths {
+		tokens = append(tokens, preprocess(tokenize(readFile(path))))
 	}
 
-	userInput = []rune(os.Args[1])
-	token = tokenize(userInput)
-	funcs := program()
-	genProgram(funcs)
+	// -E prints the result of preprocessing instead of compiling it.
+	if preprocessOnly {
+		for _, tok := range tokens {
+			if tok.kind != tkEOF {
+				printTokens(tok)
+			}
+		}
+		if errorCount > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	var progs []*Program
+	for _, tok := range tokens {
+		token = tok
+		progs = append(progs, program())
+	}
+	checkLinkage(progs)
+	if errorCount > 0 {
+		os.Exit(1)
+	}
+	genProgram(progs)
 }
@@ -1,18 +1,33 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+)
 
 var argRegs8 = []string{"dil", "sil", "dl", "cl", "r8b", "r9b"}
+var argRegs16 = []string{"di", "si", "dx", "cx", "r8w", "r9w"}
 var argRegs32 = []string{"edi", "esi", "edx", "ecx", "r8d", "r9d"}
 var argRegs64 = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
+var floatArgRegs = []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5", "xmm6", "xmm7"}
 var labelSeq = 0
 
-func genProgram(funcs []*Function) {
+// The register save area and the numbers of named parameters of the function
+// being generated, which are used by va_start.
+var vaArea *Var
+var namedGp, namedFp, namedStack int
+
+func genProgram(progs []*Program) {
 	genProgramHeader()
-	genDataSection()
+	for _, prog := range progs {
+		genDataSection(prog.globals)
+	}
+	genRodataSection()
 	genTextSectionHeader()
-	for _, f := range funcs {
-		genFunction(f)
+	for _, prog := range progs {
+		for _, f := range prog.funcs {
+			genFunction(f)
+		}
 	}
 }
 
@@ -20,18 +35,41 @@ func genProgramHeader() {
 	fmt.Printf(".intel_syntax noprefix\n")
 }
 
-func genDataSection() {
-	for name := range envGlobal.vars {
+func genDataSection(globals *Env) {
+	for name, v := range globals.vars {
+		if !hasStorage(v) {
+			continue
+		}
 		fmt.Printf(".global %s\n", name)
 	}
 
 	fmt.Printf(".bss\n")
-	for name, v := range envGlobal.vars {
+	for name, v := range globals.vars {
+		if !hasStorage(v) {
+			continue
+		}
+		fmt.Printf("  .align %d\n", v.typ.align)
 		fmt.Printf("%s:\n", name)
 		fmt.Printf("  .zero %d\n", v.typ.size)
 	}
 }
 
+// genRodataSection emits string literals of all translation units.
+func genRodataSection() {
+	fmt.Printf(".section .rodata\n")
+	for _, s := range strLits {
+		fmt.Printf("%s:\n", s.label)
+		for _, c := range s.contents {
+			fmt.Printf("  .byte %d\n", c)
+		}
+	}
+}
+
+// hasStorage reports whether v is an object placed in memory.
+func hasStorage(v *Var) bool {
+	return !v.isEnumConst && !v.isTypedef && v.typ.kind != tyFunc
+}
+
 func genTextSectionHeader() {
 	fmt.Printf(".text\n")
 	fmt.Printf(".global main\n")
@@ -40,17 +78,44 @@ func genTextSectionHeader() {
 func genFunction(f *Function) {
 	fmt.Printf("%s:\n", string(f.name))
 	genPrologue(f.env)
-	for i, param := range f.params {
-		genLoadArg(i, param)
+	gp, fp, stack := 0, 0, 0
+	for _, param := range f.params {
+		if isFlonum(param.typ) && fp < len(floatArgRegs) {
+			genLoadFloatArg(fp, param)
+			fp++
+		} else if !isFlonum(param.typ) && gp < len(argRegs64) {
+			genLoadArg(gp, param)
+			gp++
+		} else {
+			genLoadStackArg(stack, param)
+			stack++
+		}
+	}
+	vaArea = f.vaArea
+	namedGp, namedFp, namedStack = gp, fp, stack
+	if vaArea != nil {
+		genSaveArgRegs()
 	}
 	gen(f.body)
 	genEpilogue()
 }
 
+// genSaveArgRegs saves all argument registers to the register save area,
+// where variadic arguments are read by va_arg.
+func genSaveArgRegs() {
+	for i, reg := range argRegs64 {
+		fmt.Printf("  mov [rbp-%d], %s\n", vaArea.offset-8*i, reg)
+	}
+	for i, reg := range floatArgRegs {
+		fmt.Printf("  movsd [rbp-%d], %s\n", vaArea.offset-8*len(argRegs64)-16*i, reg)
+	}
+}
+
 func genPrologue(env *Env) {
 	fmt.Printf("  push rbp\n")
 	fmt.Printf("  mov rbp, rsp\n")
-	fmt.Printf("  sub rsp, %d\n", env.maxOffset)
+	// Keep RSP 16 byte aligned, which function calls rely on.
+	fmt.Printf("  sub rsp, %d\n", alignTo(env.maxOffset, 16))
 }
 
 func genEpilogue() {
@@ -73,7 +138,7 @@ func gen(node *Node) {
 		gen(node.lhs)
 		genLoad(nodeType(node))
 		return
-	case ndIf:
+	case ndIf, ndCond:
 		seq := labelSeq
 		labelSeq++
 
@@ -139,44 +204,202 @@ func gen(node *Node) {
 		genPush()
 		return
 	case ndReturn:
-		gen(node.lhs)
-		fmt.Printf("  pop rax\n")
+		if node.lhs != nil {
+			gen(node.lhs)
+			fmt.Printf("  pop rax\n")
+			if isFlonum(nodeType(node.lhs)) {
+				fmt.Printf("  movq xmm0, rax\n")
+			}
+		}
 		genEpilogue()
 		return
 	case ndFcall:
 		seq := labelSeq
 		labelSeq++
 
+		// Integer and floating arguments are passed in general purpose
+		// registers and xmm registers respectively in their own order. The
+		// rest are passed on the stack.
+		var regArgs, stackArgs []*Node
+		gp, fp := 0, 0
 		for _, arg := range node.args {
+			if isFlonum(nodeType(arg)) && fp < len(floatArgRegs) {
+				regArgs = append(regArgs, arg)
+				fp++
+			} else if !isFlonum(nodeType(arg)) && gp < len(argRegs64) {
+				regArgs = append(regArgs, arg)
+				gp++
+			} else {
+				stackArgs = append(stackArgs, arg)
+			}
+		}
+
+		// Stack arguments are pushed from the last one, so that the first
+		// one is at the top of the stack at the call.
+		for i := len(stackArgs) - 1; i >= 0; i-- {
+			gen(stackArgs[i])
+		}
+		for _, arg := range regArgs {
 			gen(arg)
 		}
-		for i := len(node.args) - 1; i >= 0; i-- {
-			fmt.Printf("  pop %s\n", argRegs64[i])
+		// The number of xmm registers used is passed in al for variadic
+		// functions.
+		numFloat := fp
+		for i := len(regArgs) - 1; i >= 0; i-- {
+			if isFlonum(nodeType(regArgs[i])) {
+				fp--
+				fmt.Printf("  pop rax\n")
+				fmt.Printf("  movq %s, rax\n", floatArgRegs[fp])
+			} else {
+				gp--
+				fmt.Printf("  pop %s\n", argRegs64[gp])
+			}
 		}
 
-		// We need to make RSP 16 byte aligned when calling function.
+		// We need to make RSP 16 byte aligned when calling function. If it
+		// is not, stack arguments are moved down by 8 bytes to make room.
+		stackSize := len(stackArgs) * 8
 		fmt.Printf("  mov rax, rsp\n")
 		fmt.Printf("  and rax, 15\n")
 		fmt.Printf("  cmp rax, 0\n")
 		fmt.Printf("  je  .L%s%d\n", "call", seq)
 		fmt.Printf("  sub rsp, 8\n")
+		for i := 0; i < len(stackArgs); i++ {
+			fmt.Printf("  mov rax, [rsp+%d]\n", 8*i+8)
+			fmt.Printf("  mov [rsp+%d], rax\n", 8*i)
+		}
+		fmt.Printf("  mov eax, %d\n", numFloat)
 		fmt.Printf("  call %s\n", node.funcName)
-		fmt.Printf("  add rsp, 8\n")
+		fmt.Printf("  add rsp, %d\n", stackSize+8)
 		fmt.Printf("  jmp .L%s%d\n", "end", seq)
 		fmt.Printf(".L%s%d:\n", "call", seq)
+		fmt.Printf("  mov eax, %d\n", numFloat)
 		fmt.Printf("  call %s\n", node.funcName)
+		if stackSize > 0 {
+			fmt.Printf("  add rsp, %d\n", stackSize)
+		}
 		fmt.Printf(".L%s%d:\n", "end", seq)
+		if isFlonum(nodeType(node)) {
+			fmt.Printf("  movq rax, xmm0\n")
+		} else {
+			genExtend(nodeType(node))
+		}
 		fmt.Printf("  push rax\n")
 		return
-	case ndVar:
-		typ := nodeType(node)
+	case ndVar, ndMember:
 		genLval(node)
-		if typ.kind != tyArray {
-			genLoad(typ)
+		genLoad(nodeType(node))
+		return
+	case ndStr:
+		genLval(node)
+		return
+	case ndLogAnd:
+		seq := labelSeq
+		labelSeq++
+
+		gen(node.lhs)
+		fmt.Printf("  pop rax\n")
+		fmt.Printf("  cmp rax, 0\n")
+		fmt.Printf("  je  .L%s%d\n", "false", seq)
+		gen(node.rhs)
+		fmt.Printf("  pop rax\n")
+		fmt.Printf("  cmp rax, 0\n")
+		fmt.Printf("  je  .L%s%d\n", "false", seq)
+		fmt.Printf("  push 1\n")
+		fmt.Printf("  jmp .L%s%d\n", "end", seq)
+		fmt.Printf(".L%s%d:\n", "false", seq)
+		fmt.Printf("  push 0\n")
+		fmt.Printf(".L%s%d:\n", "end", seq)
+		return
+	case ndLogOr:
+		seq := labelSeq
+		labelSeq++
+
+		gen(node.lhs)
+		fmt.Printf("  pop rax\n")
+		fmt.Printf("  cmp rax, 0\n")
+		fmt.Printf("  jne .L%s%d\n", "true", seq)
+		gen(node.rhs)
+		fmt.Printf("  pop rax\n")
+		fmt.Printf("  cmp rax, 0\n")
+		fmt.Printf("  jne .L%s%d\n", "true", seq)
+		fmt.Printf("  push 0\n")
+		fmt.Printf("  jmp .L%s%d\n", "end", seq)
+		fmt.Printf(".L%s%d:\n", "true", seq)
+		fmt.Printf("  push 1\n")
+		fmt.Printf(".L%s%d:\n", "end", seq)
+		return
+	case ndNot:
+		gen(node.lhs)
+		fmt.Printf("  pop rax\n")
+		fmt.Printf("  cmp rax, 0\n")
+		fmt.Printf("  sete al\n")
+		fmt.Printf("  movzx rax, al\n")
+		fmt.Printf("  push rax\n")
+		return
+	case ndComma:
+		gen(node.lhs)
+		genPop()
+		gen(node.rhs)
+		return
+	case ndNeg:
+		gen(node.lhs)
+		fmt.Printf("  pop rax\n")
+		switch typ := nodeType(node); {
+		case typ.kind == tyFloat:
+			// Flip the sign bit, which also makes -0.0 from 0.0.
+			fmt.Printf("  btc eax, 31\n")
+		case typ.kind == tyDouble:
+			fmt.Printf("  btc rax, 63\n")
+		default:
+			fmt.Printf("  neg rax\n")
+			genExtend(typ)
 		}
+		fmt.Printf("  push rax\n")
+		return
+	case ndBitNot:
+		gen(node.lhs)
+		fmt.Printf("  pop rax\n")
+		fmt.Printf("  not rax\n")
+		genExtend(nodeType(node))
+		fmt.Printf("  push rax\n")
+		return
+	case ndVaStart:
+		gen(node.lhs)
+		fmt.Printf("  pop rax\n")
+		fmt.Printf("  mov dword ptr [rax], %d\n", namedGp*8)
+		fmt.Printf("  mov dword ptr [rax+4], %d\n", len(argRegs64)*8+namedFp*16)
+		fmt.Printf("  lea rdi, [rbp+%d]\n", 16+namedStack*8)
+		fmt.Printf("  mov [rax+8], rdi\n")
+		fmt.Printf("  lea rdi, [rbp-%d]\n", vaArea.offset)
+		fmt.Printf("  mov [rax+16], rdi\n")
+		genPush()
+		return
+	case ndVaArg:
+		genVaArg(node)
+		return
+	case ndCast:
+		gen(node.lhs)
+		fmt.Printf("  pop rax\n")
+		genCast(nodeType(node.lhs), node.typ)
+		fmt.Printf("  push rax\n")
 		return
 	case ndNum:
-		fmt.Printf("  push %d\n", node.val)
+		if typ := nodeType(node); isFlonum(typ) {
+			// Floating values are kept as bit patterns on the stack.
+			if typ.kind == tyFloat {
+				fmt.Printf("  mov eax, %d\n", math.Float32bits(float32(node.fval)))
+			} else {
+				fmt.Printf("  mov rax, %d\n", int64(math.Float64bits(node.fval)))
+			}
+			fmt.Printf("  push rax\n")
+		} else if node.val == int(int32(node.val)) {
+			fmt.Printf("  push %d\n", node.val)
+		} else {
+			// push can't take a 64-bit immediate.
+			fmt.Printf("  mov rax, %d\n", node.val)
+			fmt.Printf("  push rax\n")
+		}
 		return
 	case ndNull:
 		genPush()
@@ -189,6 +412,12 @@ func gen(node *Node) {
 	fmt.Printf("  pop rdi\n")
 	fmt.Printf("  pop rax\n")
 
+	if isFlonum(operandType(node)) {
+		genFloatBinary(node)
+		fmt.Printf("  push rax\n")
+		return
+	}
+
 	switch node.kind {
 	case ndAdd, ndSub:
 		var ptrType *Type
@@ -212,13 +441,34 @@ func gen(node *Node) {
 		genCmp(node)
 	case ndAdd:
 		fmt.Printf("  add rax, rdi\n")
+		genExtend(nodeType(node))
 	case ndSub:
 		fmt.Printf("  sub rax, rdi\n")
+		genExtend(nodeType(node))
 	case ndMul:
 		fmt.Printf("  imul rax, rdi\n")
-	case ndDiv:
-		fmt.Printf("  cqo\n")
-		fmt.Printf("  idiv rdi\n")
+		genExtend(nodeType(node))
+	case ndDiv, ndMod:
+		genDiv(node.kind, nodeType(node))
+	case ndBitAnd:
+		fmt.Printf("  and rax, rdi\n")
+	case ndBitOr:
+		fmt.Printf("  or rax, rdi\n")
+	case ndBitXor:
+		fmt.Printf("  xor rax, rdi\n")
+	case ndShl:
+		fmt.Printf("  mov rcx, rdi\n")
+		fmt.Printf("  shl rax, cl\n")
+		genExtend(nodeType(node))
+	case ndShr:
+		// Signed values are shifted arithmetically, which keeps them sign
+		// extended to 64 bits.
+		fmt.Printf("  mov rcx, rdi\n")
+		if nodeType(node).isUnsigned {
+			fmt.Printf("  shr rax, cl\n")
+		} else {
+			fmt.Printf("  sar rax, cl\n")
+		}
 	}
 
 	fmt.Printf("  push rax\n")
@@ -229,12 +479,14 @@ func genLoadArg(index int, param *Var) {
 	switch param.typ.size {
 	case 1:
 		argRegs = argRegs8
+	case 2:
+		argRegs = argRegs16
 	case 4:
 		argRegs = argRegs32
 	case 8:
 		argRegs = argRegs64
 	default:
-		fatal("Loading %d byte argument is not supported: %+v", param.typ.size, param)
+		fatalTok(param.tok, "Loading %d byte argument is not supported", param.typ.size)
 	}
 
 	fmt.Printf("  mov rax, rbp\n")
@@ -242,17 +494,102 @@ func genLoadArg(index int, param *Var) {
 	fmt.Printf("  mov [rax], %s\n", argRegs[index])
 }
 
+// genVaArg pushes the address of the next variadic argument of va_list,
+// which is in the register save area until it is used up, or on the stack.
+func genVaArg(node *Node) {
+	seq := labelSeq
+	labelSeq++
+
+	offsetField, limit, step := 0, len(argRegs64)*8, 8
+	if isFlonum(node.typ.ptrTo) {
+		offsetField, limit, step = 4, len(argRegs64)*8+len(floatArgRegs)*16, 16
+	}
+
+	gen(node.lhs)
+	fmt.Printf("  pop rdi\n")
+	fmt.Printf("  mov eax, dword ptr [rdi+%d]\n", offsetField)
+	fmt.Printf("  cmp eax, %d\n", limit)
+	fmt.Printf("  jae .L%s%d\n", "overflow", seq)
+	fmt.Printf("  mov rdx, [rdi+16]\n")
+	fmt.Printf("  add rdx, rax\n")
+	fmt.Printf("  add eax, %d\n", step)
+	fmt.Printf("  mov dword ptr [rdi+%d], eax\n", offsetField)
+	fmt.Printf("  push rdx\n")
+	fmt.Printf("  jmp .L%s%d\n", "end", seq)
+	fmt.Printf(".L%s%d:\n", "overflow", seq)
+	fmt.Printf("  mov rdx, [rdi+8]\n")
+	fmt.Printf("  lea rax, [rdx+8]\n")
+	fmt.Printf("  mov [rdi+8], rax\n")
+	fmt.Printf("  push rdx\n")
+	fmt.Printf(".L%s%d:\n", "end", seq)
+}
+
+func genLoadFloatArg(index int, param *Var) {
+	fmt.Printf("  mov rax, rbp\n")
+	fmt.Printf("  sub rax, %d\n", param.offset)
+	if param.typ.kind == tyFloat {
+		fmt.Printf("  movss [rax], %s\n", floatArgRegs[index])
+	} else {
+		fmt.Printf("  movsd [rax], %s\n", floatArgRegs[index])
+	}
+}
+
+// genLoadStackArg copies the index-th argument passed on the stack, which is
+// above the return address and the saved rbp. r10 is used as scratch since
+// argument registers may still be saved for va_arg.
+func genLoadStackArg(index int, param *Var) {
+	fmt.Printf("  mov r10, [rbp+%d]\n", 16+8*index)
+	fmt.Printf("  mov rax, rbp\n")
+	fmt.Printf("  sub rax, %d\n", param.offset)
+	switch param.typ.size {
+	case 1:
+		fmt.Printf("  mov [rax], r10b\n")
+	case 2:
+		fmt.Printf("  mov [rax], r10w\n")
+	case 4:
+		fmt.Printf("  mov [rax], r10d\n")
+	case 8:
+		fmt.Printf("  mov [rax], r10\n")
+	default:
+		fatalTok(param.tok, "Loading %d byte argument is not supported", param.typ.size)
+	}
+}
+
 func genLoad(typ *Type) {
+	if typ.kind == tyArray || isAggregate(typ) {
+		// The address itself is the value.
+		return
+	}
 	fmt.Printf("  pop rax\n")
+	if typ.kind == tyFloat {
+		fmt.Printf("  mov eax, dword ptr [rax]\n")
+		fmt.Printf("  push rax\n")
+		return
+	}
 	switch typ.size {
 	case 1:
-		fmt.Printf("  movsx rax, byte ptr [rax]\n")
+		if typ.isUnsigned {
+			fmt.Printf("  movzx rax, byte ptr [rax]\n")
+		} else {
+			fmt.Printf("  movsx rax, byte ptr [rax]\n")
+		}
+	case 2:
+		if typ.isUnsigned {
+			fmt.Printf("  movzx rax, word ptr [rax]\n")
+		} else {
+			fmt.Printf("  movsx rax, word ptr [rax]\n")
+		}
 	case 4:
-		fmt.Printf("  mov eax, dword ptr [rax]\n")
+		if typ.isUnsigned {
+			// Writing to eax clears the upper 32 bits.
+			fmt.Printf("  mov eax, dword ptr [rax]\n")
+		} else {
+			fmt.Printf("  movsxd rax, dword ptr [rax]\n")
+		}
 	case 8:
 		fmt.Printf("  mov rax, [rax]\n")
 	default:
-		fatal("Loading %d byte value is not supported: %+v", typ.size, typ)
+		fatal("Loading %d byte value is not supported", typ.size)
 	}
 	fmt.Printf("  push rax\n")
 }
@@ -260,15 +597,30 @@ func genLoad(typ *Type) {
 func genStore(typ *Type) {
 	fmt.Printf("  pop rdi\n")
 	fmt.Printf("  pop rax\n")
+	if isAggregate(typ) {
+		for i := 0; i < typ.size; i++ {
+			fmt.Printf("  mov r8b, [rdi+%d]\n", i)
+			fmt.Printf("  mov [rax+%d], r8b\n", i)
+		}
+		fmt.Printf("  push rax\n")
+		return
+	}
+	if typ.kind == tyBool {
+		fmt.Printf("  cmp rdi, 0\n")
+		fmt.Printf("  setne dil\n")
+		fmt.Printf("  movzx rdi, dil\n")
+	}
 	switch typ.size {
 	case 1:
 		fmt.Printf("  mov [rax], dil\n")
+	case 2:
+		fmt.Printf("  mov [rax], di\n")
 	case 4:
 		fmt.Printf("  mov [rax], edi\n")
 	case 8:
 		fmt.Printf("  mov [rax], rdi\n")
 	default:
-		fatal("Storing %d byte value is not supported: %+v", typ.size, typ)
+		fatal("Storing %d byte value is not supported", typ.size)
 	}
 	fmt.Printf("  push rdi\n")
 }
@@ -285,26 +637,239 @@ func genLval(node *Node) {
 			fmt.Printf("  sub rax, %d\n", node.vble.offset)
 			fmt.Printf("  push rax\n")
 		}
+	case ndStr:
+		fmt.Printf("  push offset %s\n", node.strLit.label)
+	case ndMember:
+		genLval(node.lhs)
+		fmt.Printf("  pop rax\n")
+		fmt.Printf("  add rax, %d\n", node.member.offset)
+		fmt.Printf("  push rax\n")
 	default:
-		fatal("Left-hand side of assign expression is not assignable")
+		fatalTok(node.tok, "Expression is not assignable")
 	}
 }
 
 func genCmp(node *Node) {
-	fmt.Printf("  cmp rax, rdi\n")
+	typ := operandType(node)
+	if typ.size == 8 {
+		fmt.Printf("  cmp rax, rdi\n")
+	} else {
+		fmt.Printf("  cmp eax, edi\n")
+	}
 	switch node.kind {
 	case ndEq:
 		fmt.Printf("  sete al\n")
 	case ndNe:
 		fmt.Printf("  setne al\n")
 	case ndLt:
-		fmt.Printf("  setl al\n")
+		if typ.isUnsigned {
+			fmt.Printf("  setb al\n")
+		} else {
+			fmt.Printf("  setl al\n")
+		}
 	case ndLe:
-		fmt.Printf("  setle al\n")
+		if typ.isUnsigned {
+			fmt.Printf("  setbe al\n")
+		} else {
+			fmt.Printf("  setle al\n")
+		}
 	}
 	fmt.Printf("  movzb rax, al\n")
 }
 
+// operandType returns the type which both operands of a binary operator are
+// converted to.
+func operandType(node *Node) *Type {
+	ltype := nodeType(node.lhs)
+	rtype := nodeType(node.rhs)
+	if isNumeric(ltype) && isNumeric(rtype) {
+		return usualArithType(ltype, rtype)
+	}
+	// Pointers are compared as unsigned addresses.
+	return typeULong
+}
+
+// genFloatBinary computes a binary operator on floating values in rax and
+// rdi with SSE instructions. The result is left in rax.
+func genFloatBinary(node *Node) {
+	typ := operandType(node)
+	suffix := "sd"
+	if typ.kind == tyFloat {
+		suffix = "ss"
+	}
+	fmt.Printf("  movq xmm0, rax\n")
+	fmt.Printf("  movq xmm1, rdi\n")
+
+	switch node.kind {
+	case ndAdd:
+		fmt.Printf("  add%s xmm0, xmm1\n", suffix)
+	case ndSub:
+		fmt.Printf("  sub%s xmm0, xmm1\n", suffix)
+	case ndMul:
+		fmt.Printf("  mul%s xmm0, xmm1\n", suffix)
+	case ndDiv:
+		fmt.Printf("  div%s xmm0, xmm1\n", suffix)
+	case ndEq, ndNe, ndLt, ndLe:
+		// Comparisons with NaN are false except for !=, which is reported
+		// by the parity flag.
+		switch node.kind {
+		case ndEq:
+			fmt.Printf("  ucomi%s xmm0, xmm1\n", suffix)
+			fmt.Printf("  sete al\n")
+			fmt.Printf("  setnp dl\n")
+			fmt.Printf("  and al, dl\n")
+		case ndNe:
+			fmt.Printf("  ucomi%s xmm0, xmm1\n", suffix)
+			fmt.Printf("  setne al\n")
+			fmt.Printf("  setp dl\n")
+			fmt.Printf("  or al, dl\n")
+		case ndLt:
+			fmt.Printf("  ucomi%s xmm1, xmm0\n", suffix)
+			fmt.Printf("  seta al\n")
+		case ndLe:
+			fmt.Printf("  ucomi%s xmm1, xmm0\n", suffix)
+			fmt.Printf("  setae al\n")
+		}
+		fmt.Printf("  movzx rax, al\n")
+		return
+	}
+	fmt.Printf("  movq rax, xmm0\n")
+}
+
+// genCast converts the value in rax from a type to another.
+func genCast(from *Type, to *Type) {
+	switch {
+	case to.kind == tyVoid:
+		// The value is discarded.
+	case to.kind == tyBool && isFlonum(from):
+		genFloatToBool(from)
+	case to.kind == tyBool:
+		fmt.Printf("  cmp rax, 0\n")
+		fmt.Printf("  setne al\n")
+		fmt.Printf("  movzx rax, al\n")
+	case isFlonum(from) && isFlonum(to):
+		if from.kind == tyFloat && to.kind == tyDouble {
+			fmt.Printf("  movq xmm0, rax\n")
+			fmt.Printf("  cvtss2sd xmm0, xmm0\n")
+			fmt.Printf("  movq rax, xmm0\n")
+		} else if from.kind == tyDouble && to.kind == tyFloat {
+			fmt.Printf("  movq xmm0, rax\n")
+			fmt.Printf("  cvtsd2ss xmm0, xmm0\n")
+			fmt.Printf("  movd eax, xmm0\n")
+		}
+	case isFlonum(from):
+		suffix := "sd"
+		if from.kind == tyFloat {
+			suffix = "ss"
+		}
+		fmt.Printf("  movq xmm0, rax\n")
+		fmt.Printf("  cvtt%s2si rax, xmm0\n", suffix)
+		genExtend(to)
+	case isFlonum(to):
+		genIntToFloat(from, to)
+	default:
+		genExtend(to)
+	}
+}
+
+func genFloatToBool(from *Type) {
+	suffix := "sd"
+	if from.kind == tyFloat {
+		suffix = "ss"
+	}
+	fmt.Printf("  movq xmm0, rax\n")
+	fmt.Printf("  xorps xmm1, xmm1\n")
+	fmt.Printf("  ucomi%s xmm0, xmm1\n", suffix)
+	fmt.Printf("  setne al\n")
+	fmt.Printf("  setp dl\n")
+	fmt.Printf("  or al, dl\n")
+	fmt.Printf("  movzx rax, al\n")
+}
+
+func genIntToFloat(from *Type, to *Type) {
+	suffix := "sd"
+	if to.kind == tyFloat {
+		suffix = "ss"
+	}
+	if from.size == 8 && from.isUnsigned {
+		// cvtsi2sd takes a signed value. Values with the top bit set are
+		// halved, keeping the lowest bit for rounding, and doubled after
+		// the conversion.
+		seq := labelSeq
+		labelSeq++
+		fmt.Printf("  pxor xmm0, xmm0\n")
+		fmt.Printf("  test rax, rax\n")
+		fmt.Printf("  js  .L%s%d\n", "ulong", seq)
+		fmt.Printf("  cvtsi2%s xmm0, rax\n", suffix)
+		fmt.Printf("  jmp .L%s%d\n", "end", seq)
+		fmt.Printf(".L%s%d:\n", "ulong", seq)
+		fmt.Printf("  mov rdi, rax\n")
+		fmt.Printf("  and eax, 1\n")
+		fmt.Printf("  shr rdi\n")
+		fmt.Printf("  or rdi, rax\n")
+		fmt.Printf("  cvtsi2%s xmm0, rdi\n", suffix)
+		fmt.Printf("  add%s xmm0, xmm0\n", suffix)
+		fmt.Printf(".L%s%d:\n", "end", seq)
+	} else {
+		// Integers are already extended to 64 bits.
+		fmt.Printf("  pxor xmm0, xmm0\n")
+		fmt.Printf("  cvtsi2%s xmm0, rax\n", suffix)
+	}
+	if to.kind == tyFloat {
+		fmt.Printf("  movd eax, xmm0\n")
+	} else {
+		fmt.Printf("  movq rax, xmm0\n")
+	}
+}
+
+// genDiv divides rax by rdi and leaves the quotient, or the remainder for
+// %, in rax.
+func genDiv(kind NodeKind, typ *Type) {
+	switch {
+	case typ.size == 8 && typ.isUnsigned:
+		fmt.Printf("  mov edx, 0\n")
+		fmt.Printf("  div rdi\n")
+	case typ.size == 8:
+		fmt.Printf("  cqo\n")
+		fmt.Printf("  idiv rdi\n")
+	case typ.isUnsigned:
+		fmt.Printf("  mov edx, 0\n")
+		fmt.Printf("  div edi\n")
+	default:
+		fmt.Printf("  cdq\n")
+		fmt.Printf("  idiv edi\n")
+	}
+	if kind == ndMod {
+		fmt.Printf("  mov rax, rdx\n")
+	}
+	genExtend(typ)
+}
+
+// genExtend sign or zero extends the value of typ in rax to 64 bits, which
+// is the representation of every integer value on the stack.
+func genExtend(typ *Type) {
+	switch typ.size {
+	case 1:
+		if typ.isUnsigned {
+			fmt.Printf("  movzx rax, al\n")
+		} else {
+			fmt.Printf("  movsx rax, al\n")
+		}
+	case 2:
+		if typ.isUnsigned {
+			fmt.Printf("  movzx rax, ax\n")
+		} else {
+			fmt.Printf("  movsx rax, ax\n")
+		}
+	case 4:
+		if typ.isUnsigned {
+			fmt.Printf("  mov eax, eax\n")
+		} else {
+			fmt.Printf("  movsxd rax, eax\n")
+		}
+	}
+}
+
 func genPush() {
 	fmt.Printf("  push 0xdb\n")
 }
@@ -0,0 +1,10 @@
+#ifndef __STDARG_H
+#define __STDARG_H
+
+// va_list, va_start, va_arg, va_end and va_copy are built into 9cc.
+typedef va_list __gnuc_va_list;
+#define __GNUC_VA_LIST 1
+
+#endif
+
+#undef __need___va_list
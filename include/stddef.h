@@ -0,0 +1,18 @@
+#ifndef __STDDEF_H
+#define __STDDEF_H
+
+typedef unsigned long size_t;
+typedef long ptrdiff_t;
+typedef int wchar_t;
+typedef long max_align_t;
+
+#define NULL ((void *)0)
+#define offsetof(type, member) ((unsigned long)&((type *)0)->member)
+
+#endif
+
+// System headers request some of the definitions with these macros.
+#undef __need_size_t
+#undef __need_ptrdiff_t
+#undef __need_wchar_t
+#undef __need_NULL
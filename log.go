@@ -6,7 +6,15 @@ import (
 	"strings"
 )
 
-var userInput []rune
+// Number of errors reported so far
+var errorCount int
+
+// Compilation stops when errorCount reaches errorLimit. 0 means no limit.
+var errorLimit = 20
+
+// syntaxError is a panic value to unwind the parser to a point where it
+// can resume parsing.
+type syntaxError struct{}
 
 func fatal(format string, a ...interface{}) {
 	fmt.Fprintf(os.Stderr, format, a...)
@@ -14,11 +22,66 @@ func fatal(format string, a ...interface{}) {
 	os.Exit(1)
 }
 
-func fatalAt(pos int, format string, a ...interface{}) {
-	fmt.Fprintf(os.Stderr, "%s\n", string(userInput))
-	fmt.Fprintf(os.Stderr, strings.Repeat(" ", pos))
-	fmt.Fprintf(os.Stderr, "^ ")
+// fatalAt reports an error at pos and exits immediately.
+func fatalAt(pos Pos, format string, a ...interface{}) {
+	printDiagnostic(pos, 1, format, a...)
+	os.Exit(1)
+}
+
+// fatalTok is similar to fatalAt but underlines the whole token.
+func fatalTok(tok *Token, format string, a ...interface{}) {
+	printDiagnostic(tok.pos, len(tok.str), format, a...)
+	os.Exit(1)
+}
+
+// errorAt reports an error at pos and lets the compiler continue so that
+// more errors can be found in a single run.
+func errorAt(pos Pos, format string, a ...interface{}) {
+	printDiagnostic(pos, 1, format, a...)
+	countError()
+}
+
+// errorTok is similar to errorAt but underlines the whole token.
+func errorTok(tok *Token, format string, a ...interface{}) {
+	printDiagnostic(tok.pos, len(tok.str), format, a...)
+	countError()
+}
+
+// syntaxErrorTok reports an error and unwinds the parser to the nearest
+// recovery point in stmt() or toplv().
+func syntaxErrorTok(tok *Token, format string, a ...interface{}) {
+	errorTok(tok, format, a...)
+	panic(syntaxError{})
+}
+
+func countError() {
+	errorCount++
+	if errorLimit > 0 && errorCount >= errorLimit {
+		fatal("compilation terminated due to -fmax-errors=%d.", errorLimit)
+	}
+}
+
+// printDiagnostic prints an error with the source line and a caret
+// in the same format as gcc and clang.
+func printDiagnostic(pos Pos, length int, format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%s:%d:%d: error: ", pos.file.name, pos.lineNo(), pos.col)
 	fmt.Fprintf(os.Stderr, format, a...)
 	fmt.Fprintf(os.Stderr, "\n")
-	os.Exit(1)
+
+	line := pos.file.lineText(pos.line)
+	fmt.Fprintf(os.Stderr, "%s\n", string(line))
+
+	// Keep tabs so that the caret is aligned with the line above.
+	var indent strings.Builder
+	for _, r := range line[:pos.col-1] {
+		if r == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+	if length < 1 {
+		length = 1
+	}
+	fmt.Fprintf(os.Stderr, "%s^%s\n", indent.String(), strings.Repeat("~", length-1))
 }
@@ -1,77 +1,311 @@
 package main
 
+import "fmt"
+
 type TypeKind int
 
 const (
 	tyInt = iota
 	tyChar
+	tyShort
+	tyLong
+	tyBool
+	tyFloat
+	tyDouble
 	tyPtr
 	tyArray
+	tyStruct
+	tyUnion
+	tyEnum
+	tyVoid
+	tyFunc
 )
 
 type Type struct {
-	kind      TypeKind
-	size      int   // sizeof
-	ptrTo     *Type // tyPtr: referenced type, tyArray: element type
-	arraySize int   // num of elements of array
+	kind       TypeKind
+	size       int       // sizeof
+	align      int       // alignof
+	isUnsigned bool      // Valid only if integer type
+	ptrTo      *Type     // tyPtr: referenced type, tyArray: element type
+	arraySize  int       // num of elements of array
+	members    []*Member // Members of struct or union
+	retTyp     *Type     // tyFunc: return type
+	params     []*Type   // tyFunc: parameter types
+	noProto    bool      // tyFunc: declared with "()", parameters are unknown
+	isVariadic bool      // tyFunc: declared with "..."
+
+	// Struct or union declared but not defined yet
+	isIncomplete bool
+}
+
+// Member is a member of struct or union.
+type Member struct {
+	name   []rune // nil for anonymous struct or union member
+	typ    *Type
+	tok    *Token
+	offset int
+}
+
+// va_list is an array of one structure which holds gp_offset, fp_offset,
+// overflow_arg_area and reg_save_area as defined by the System V ABI.
+var typeVaListElem = &Type{kind: tyStruct, size: 24, align: 8}
+var typeVaList = typeArray(typeVaListElem, 1)
+
+// The size of void is 1 for arithmetic on void pointers as GCC does.
+var typeVoid = &Type{kind: tyVoid, size: 1, align: 1}
+var typeBool = &Type{kind: tyBool, size: 1, align: 1, isUnsigned: true}
+var typeChar = &Type{kind: tyChar, size: 1, align: 1}
+var typeShort = &Type{kind: tyShort, size: 2, align: 2}
+var typeInt = &Type{kind: tyInt, size: 4, align: 4}
+var typeLong = &Type{kind: tyLong, size: 8, align: 8}
+var typeUChar = &Type{kind: tyChar, size: 1, align: 1, isUnsigned: true}
+var typeUShort = &Type{kind: tyShort, size: 2, align: 2, isUnsigned: true}
+var typeUInt = &Type{kind: tyInt, size: 4, align: 4, isUnsigned: true}
+var typeULong = &Type{kind: tyLong, size: 8, align: 8, isUnsigned: true}
+var typeFloat = &Type{kind: tyFloat, size: 4, align: 4}
+var typeDouble = &Type{kind: tyDouble, size: 8, align: 8}
+
+func isInteger(typ *Type) bool {
+	switch typ.kind {
+	case tyBool, tyChar, tyShort, tyInt, tyLong, tyEnum:
+		return true
+	}
+	return false
+}
+
+func isFlonum(typ *Type) bool {
+	return typ.kind == tyFloat || typ.kind == tyDouble
+}
+
+func isNumeric(typ *Type) bool {
+	return isInteger(typ) || isFlonum(typ)
+}
+
+// promote returns the type of an integer operand after the integer
+// promotions. Types smaller than int are promoted to int.
+func promote(typ *Type) *Type {
+	if typ.size < typeInt.size {
+		return typeInt
+	}
+	return typ
 }
 
-var typeInt = &Type{kind: tyInt, size: 4}
-var typeChar = &Type{kind: tyChar, size: 1}
+// usualArithType returns the common type of operands of a binary arithmetic
+// operator by the usual arithmetic conversions.
+func usualArithType(ltype *Type, rtype *Type) *Type {
+	if isFlonum(ltype) || isFlonum(rtype) {
+		if ltype.kind == tyDouble || rtype.kind == tyDouble {
+			return typeDouble
+		}
+		return typeFloat
+	}
+	ltype = promote(ltype)
+	rtype = promote(rtype)
+	if ltype.size != rtype.size {
+		if ltype.size > rtype.size {
+			return ltype
+		}
+		return rtype
+	}
+	if rtype.isUnsigned {
+		return rtype
+	}
+	return ltype
+}
 
 func typePtrTo(ptrTo *Type) *Type {
 	return &Type{
 		kind:  tyPtr,
 		size:  8,
+		align: 8,
 		ptrTo: ptrTo,
 	}
 }
 
+func typeFunc(retTyp *Type, params []*Type) *Type {
+	return &Type{
+		kind:   tyFunc,
+		size:   1,
+		align:  1,
+		retTyp: retTyp,
+		params: params,
+	}
+}
+
 func typeArray(ptrTo *Type, arraySize int) *Type {
 	return &Type{
 		kind:      tyArray,
 		size:      arraySize * ptrTo.size,
+		align:     ptrTo.align,
 		ptrTo:     ptrTo,
 		arraySize: arraySize,
 	}
 }
 
+// isScalar reports whether typ is an arithmetic or pointer type. Arrays are
+// scalar as values since they decay to pointers.
+func isScalar(typ *Type) bool {
+	return isNumeric(typ) || typ.kind == tyPtr || typ.kind == tyArray
+}
+
+func isAggregate(typ *Type) bool {
+	return typ.kind == tyStruct || typ.kind == tyUnion
+}
+
+// findMember looks up a member by name. Members of anonymous struct or union
+// members are found as if they were members of typ.
+func findMember(typ *Type, name []rune) *Member {
+	for _, m := range typ.members {
+		if m.name == nil {
+			if inner := findMember(m.typ, name); inner != nil {
+				return &Member{
+					name:   inner.name,
+					typ:    inner.typ,
+					tok:    inner.tok,
+					offset: m.offset + inner.offset,
+				}
+			}
+			continue
+		}
+		if string(m.name) == string(name) {
+			return m
+		}
+	}
+	return nil
+}
+
+// isCompatible reports whether two declarations of the same object or
+// function have the same type.
+func isCompatible(t1 *Type, t2 *Type) bool {
+	if t1 == t2 {
+		return true
+	}
+	if t1.kind != t2.kind {
+		return false
+	}
+	switch t1.kind {
+	case tyPtr:
+		return isCompatible(t1.ptrTo, t2.ptrTo)
+	case tyArray:
+		return t1.arraySize == t2.arraySize && isCompatible(t1.ptrTo, t2.ptrTo)
+	case tyFunc:
+		if !isCompatible(t1.retTyp, t2.retTyp) {
+			return false
+		}
+		if t1.noProto || t2.noProto {
+			return true
+		}
+		if t1.isVariadic != t2.isVariadic || len(t1.params) != len(t2.params) {
+			return false
+		}
+		for i := range t1.params {
+			if !isCompatible(t1.params[i], t2.params[i]) {
+				return false
+			}
+		}
+		return true
+	case tyStruct, tyUnion, tyEnum:
+		return false
+	}
+	return t1.size == t2.size && t1.isUnsigned == t2.isUnsigned
+}
+
+// alignTo rounds n up to the nearest multiple of align.
+func alignTo(n int, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// Var is a variable, an enumerator or a typedef name, which share the same
+// name space.
 type Var struct {
 	typ      *Type
 	name     []rune
-	offset   int // Valid only if isGlobal = false
+	tok      *Token // Token where the variable is declared
+	offset   int    // Valid only if isGlobal = false
 	isGlobal bool
+
+	isEnumConst bool
+	enumVal     int // Valid only if isEnumConst = true
+
+	isTypedef bool // typ is the aliased type
+
+	isDefined bool // Function has a body
 }
 
-func newLocalVar(typ *Type, name []rune) *Var {
-	str := string(name)
+func newLocalVar(typ *Type, tok *Token) *Var {
+	str := string(tok.str)
 	if _, exist := env.vars[str]; exist {
-		fatal("Variable \"%s\" is already defined", str)
+		errorTok(tok, "Variable \"%s\" is already defined", str)
 	}
 	v := &Var{
 		typ:    typ,
-		name:   name,
-		offset: env.maxOffset + typ.size,
+		name:   tok.str,
+		tok:    tok,
+		offset: alignTo(env.maxOffset+typ.size, typ.align),
 	}
 	env.vars[str] = v
 	env.maxOffset = v.offset
 	return v
 }
 
-func newGlobalVar(typ *Type, name []rune) *Var {
-	str := string(name)
+// newTempVar allocates an anonymous local variable used by the compiler.
+func newTempVar(typ *Type, tok *Token) *Var {
+	v := &Var{
+		typ:    typ,
+		name:   []rune("__tmp__"),
+		tok:    tok,
+		offset: alignTo(env.maxOffset+typ.size, typ.align),
+	}
+	env.maxOffset = v.offset
+	return v
+}
+
+func newGlobalVar(typ *Type, tok *Token) *Var {
+	str := string(tok.str)
 	if _, exist := envGlobal.vars[str]; exist {
-		fatal("Variable \"%s\" is already defined", str)
+		errorTok(tok, "Variable \"%s\" is already defined", str)
 	}
 	v := &Var{
 		typ:      typ,
-		name:     name,
+		name:     tok.str,
+		tok:      tok,
 		isGlobal: true,
 	}
 	envGlobal.vars[str] = v
 	return v
 }
 
+func newEnumConst(val int, tok *Token) *Var {
+	str := string(tok.str)
+	if _, exist := env.vars[str]; exist {
+		errorTok(tok, "Variable \"%s\" is already defined", str)
+	}
+	v := &Var{
+		typ:         typeInt,
+		name:        tok.str,
+		tok:         tok,
+		isEnumConst: true,
+		enumVal:     val,
+	}
+	env.vars[str] = v
+	return v
+}
+
+func newTypedef(typ *Type, tok *Token) *Var {
+	str := string(tok.str)
+	if _, exist := env.vars[str]; exist {
+		errorTok(tok, "Variable \"%s\" is already defined", str)
+	}
+	v := &Var{
+		typ:       typ,
+		name:      tok.str,
+		tok:       tok,
+		isTypedef: true,
+	}
+	env.vars[str] = v
+	return v
+}
+
 func findVar(name []rune) *Var {
 	str := string(name)
 	v := env.vars[str]
@@ -81,55 +315,120 @@ func findVar(name []rune) *Var {
 	return v
 }
 
+func findTag(name []rune) *Type {
+	str := string(name)
+	typ := env.tags[str]
+	if typ == nil {
+		typ = envGlobal.tags[str]
+	}
+	return typ
+}
+
 type Env struct {
 	vars      map[string]*Var
+	tags      map[string]*Type // Struct, union and enum tags
 	maxOffset int
+
+	// Names already reported as undefined in this scope
+	undefined map[string]bool
 }
 
 var env *Env
 var envGlobal *Env
 
+// currentFunc is the function whose body is being parsed.
+var currentFunc *Var
+
 func newEnv() *Env {
 	env = &Env{
-		vars: make(map[string]*Var),
+		vars:      make(map[string]*Var),
+		tags:      make(map[string]*Type),
+		undefined: make(map[string]bool),
 	}
 	return env
 }
 
+// StrLit is a string literal placed in the read-only data section.
+type StrLit struct {
+	label    string
+	contents []byte // Including the terminating NUL
+}
+
+var strLits []*StrLit
+
+// newStrLit returns a string literal. Identical literals share the storage.
+func newStrLit(contents []byte) *StrLit {
+	contents = append(contents, 0)
+	for _, s := range strLits {
+		if string(s.contents) == string(contents) {
+			return s
+		}
+	}
+	s := &StrLit{
+		label:    fmt.Sprintf(".Lstr%d", len(strLits)),
+		contents: contents,
+	}
+	strLits = append(strLits, s)
+	return s
+}
+
 type Function struct {
 	name   []rune
 	env    *Env
 	params []*Var
 	body   *Node
+
+	// Register save area for va_start, which is nil unless variadic
+	vaArea *Var
 }
 
 type NodeKind int
 
 const (
-	ndEq     = iota // ==
-	ndNe            // !=
-	ndLt            // <
-	ndLe            // <=
-	ndAdd           // +
-	ndSub           // -
-	ndMul           // *
-	ndDiv           // /
-	ndAssign        // =
-	ndAddr          // unary &
-	ndDeref         // unary *
-	ndIf            // "if"
-	ndWhile         // "while"
-	ndFor           // "for"
-	ndBlock         // { ... }
-	ndReturn        // "return"
-	ndFcall         // Function call
-	ndVar           // Variable
-	ndNum           // Integer
-	ndNull          // Null statement
+	ndEq      = iota // ==
+	ndNe             // !=
+	ndLt             // <
+	ndLe             // <=
+	ndAdd            // +
+	ndSub            // -
+	ndMul            // *
+	ndDiv            // /
+	ndMod            // %
+	ndBitAnd         // &
+	ndBitOr          // |
+	ndBitXor         // ^
+	ndBitNot         // ~
+	ndNeg            // unary -
+	ndShl            // <<
+	ndShr            // >>
+	ndLogAnd         // &&
+	ndLogOr          // ||
+	ndNot            // !
+	ndCond           // ?:
+	ndComma          // ,
+	ndAssign         // =
+	ndAddr           // unary &
+	ndDeref          // unary *
+	ndMember         // . (struct member access)
+	ndIf             // "if"
+	ndWhile          // "while"
+	ndFor            // "for"
+	ndBlock          // { ... }
+	ndReturn         // "return"
+	ndFcall          // Function call
+	ndVar            // Variable
+	ndNum            // Integer or floating point number
+	ndCast           // Type conversion
+	ndVaStart        // va_start
+	ndVaArg          // Address of the next variadic argument
+	ndStr            // String literal
+	ndNull           // Null statement
 )
 
 type Node struct {
 	kind NodeKind
+	tok  *Token // Representative token for diagnostics
+	typ  *Type  // Cache of nodeType()
 
 	lhs *Node // Left-hand side
 	rhs *Node // Right-hand side
@@ -146,47 +445,69 @@ type Node struct {
 
 	// Function call
 	funcName string
+	funcType *Type // nil if the function is not declared
 	args     []*Node
 
 	// Variable
 	vble *Var
 
+	// Struct member access
+	member *Member
+
 	// Number literal
-	val int
+	val  int
+	fval float64
+
+	// String literal
+	strLit *StrLit
 }
 
 var nullNode = &Node{
 	kind: ndNull,
 }
 
-func newNode(kind NodeKind, lhs *Node, rhs *Node) *Node {
+func newNode(kind NodeKind, lhs *Node, rhs *Node, tok *Token) *Node {
 	return &Node{
 		kind: kind,
+		tok:  tok,
 		lhs:  lhs,
 		rhs:  rhs,
 	}
 }
 
-func newNodeIf(test *Node, cons *Node, alt *Node) *Node {
+func newNodeIf(test *Node, cons *Node, alt *Node, tok *Token) *Node {
 	return &Node{
 		kind: ndIf,
+		tok:  tok,
+		test: test,
+		cons: cons,
+		alt:  alt,
+	}
+}
+
+func newNodeCond(test *Node, cons *Node, alt *Node, tok *Token) *Node {
+	return &Node{
+		kind: ndCond,
+		tok:  tok,
 		test: test,
 		cons: cons,
 		alt:  alt,
 	}
 }
 
-func newNodeWhile(test *Node, cons *Node) *Node {
+func newNodeWhile(test *Node, cons *Node, tok *Token) *Node {
 	return &Node{
 		kind: ndWhile,
+		tok:  tok,
 		test: test,
 		cons: cons,
 	}
 }
 
-func newNodeFor(init *Node, test *Node, post *Node, cons *Node) *Node {
+func newNodeFor(init *Node, test *Node, post *Node, cons *Node, tok *Token) *Node {
 	return &Node{
 		kind: ndFor,
+		tok:  tok,
 		init: init,
 		test: test,
 		post: post,
@@ -194,160 +515,887 @@ func newNodeFor(init *Node, test *Node, post *Node, cons *Node) *Node {
 	}
 }
 
-func newNodeBlock(body []*Node) *Node {
+func newNodeBlock(body []*Node, tok *Token) *Node {
 	return &Node{
 		kind: ndBlock,
+		tok:  tok,
 		body: body,
 	}
 }
 
-func newNodeFcall(name []rune, args []*Node) *Node {
-	return &Node{
+func newNodeFcall(tok *Token, args []*Node) *Node {
+	node := &Node{
 		kind:     ndFcall,
-		funcName: string(name),
+		tok:      tok,
+		funcName: string(tok.str),
 		args:     args,
 	}
+	v := findVar(tok.str)
+	if v == nil {
+		// Implicitly declared function, which is not checked.
+		return node
+	}
+	if v.typ.kind != tyFunc {
+		errorTok(tok, "Called object \"%s\" is not a function", string(tok.str))
+		return node
+	}
+	node.funcType = v.typ
+	if !v.typ.noProto {
+		if len(args) < len(v.typ.params) {
+			errorTok(tok, "Too few arguments to function \"%s\", expected %d, have %d", string(tok.str), len(v.typ.params), len(args))
+		} else if len(args) > len(v.typ.params) && !v.typ.isVariadic {
+			errorTok(tok, "Too many arguments to function \"%s\", expected %d, have %d", string(tok.str), len(v.typ.params), len(args))
+		}
+	}
+	return node
 }
 
-func newNodeVar(name []rune) *Node {
-	v := findVar(name)
+func newNodeVar(tok *Token) *Node {
+	v := findVar(tok.str)
 	if v == nil {
-		fatal("Variable \"%s\" is not defined", string(name))
+		if !env.undefined[string(tok.str)] {
+			errorTok(tok, "Variable \"%s\" is not defined", string(tok.str))
+			env.undefined[string(tok.str)] = true
+		}
+		return newNodePlaceholder(tok)
+	}
+	if v.isEnumConst {
+		return newNodeNum(v.enumVal, tok)
 	}
+	if v.isTypedef {
+		syntaxErrorTok(tok, "Unexpected type name \"%s\"", string(tok.str))
+	}
+	return &Node{
+		kind: ndVar,
+		tok:  tok,
+		vble: v,
+	}
+}
+
+// newNodeTempVar returns a reference to a variable made by newTempVar.
+func newNodeTempVar(v *Var, tok *Token) *Node {
 	return &Node{
 		kind: ndVar,
+		tok:  tok,
 		vble: v,
 	}
 }
 
-func newNodeNum(val int) *Node {
+// evalLvalOnce prepares lhs to be referred to more than once. It returns
+// "tmp = &lhs" and a function building "*tmp". A variable is used as is
+// with nil initialization since evaluating it has no side effects.
+func evalLvalOnce(lhs *Node, tok *Token) (*Node, func() *Node) {
+	if lhs.kind == ndVar {
+		return nil, func() *Node { return lhs }
+	}
+	tmp := newTempVar(typePtrTo(nodeType(lhs)), tok)
+	init := newNode(ndAssign, newNodeTempVar(tmp, tok), newNode(ndAddr, lhs, nil, tok), tok)
+	return init, func() *Node {
+		return newNode(ndDeref, newNodeTempVar(tmp, tok), nil, tok)
+	}
+}
+
+// newNodeAssignOp builds "lhs op= rhs" as "tmp = &lhs, *tmp = *tmp op rhs"
+// so that lhs is evaluated only once.
+func newNodeAssignOp(kind NodeKind, lhs *Node, rhs *Node, tok *Token) *Node {
+	if !isLval(lhs) {
+		// The assignment reports the error.
+		return newNode(ndAssign, lhs, newNode(kind, lhs, rhs, tok), tok)
+	}
+	init, lval := evalLvalOnce(lhs, tok)
+	node := newNode(ndAssign, lval(), newNode(kind, lval(), rhs, tok), tok)
+	if init != nil {
+		node = newNode(ndComma, init, node, tok)
+	}
+	return node
+}
+
+// newNodePostIncDec builds "lhs++" and "lhs--" as
+// "tmp = &lhs, old = *tmp, *tmp = old + n, old", where n is 1 or -1.
+func newNodePostIncDec(lhs *Node, n int, tok *Token) *Node {
+	typ := nodeType(lhs)
+	if !isLval(lhs) || typ.kind == tyArray {
+		return newNodeAssignOp(ndAdd, lhs, newNodeNum(n, tok), tok)
+	}
+	init, lval := evalLvalOnce(lhs, tok)
+	old := newTempVar(typ, tok)
+	node := newNode(ndAssign, newNodeTempVar(old, tok), lval(), tok)
+	if init != nil {
+		node = newNode(ndComma, init, node, tok)
+	}
+	inc := newNode(ndAdd, newNodeTempVar(old, tok), newNodeNum(n, tok), tok)
+	node = newNode(ndComma, node, newNode(ndAssign, lval(), inc, tok), tok)
+	return newNode(ndComma, node, newNodeTempVar(old, tok), tok)
+}
+
+// newNodePlaceholder returns an int variable, which is not defined in any
+// scope, in place of an erroneous expression to avoid cascading errors.
+func newNodePlaceholder(tok *Token) *Node {
+	return &Node{
+		kind: ndVar,
+		tok:  tok,
+		vble: &Var{
+			typ:  typeInt,
+			name: tok.str,
+			tok:  tok,
+		},
+	}
+}
+
+func newNodeNum(val int, tok *Token) *Node {
 	return &Node{
 		kind: ndNum,
+		tok:  tok,
 		val:  val,
 	}
 }
 
+func newNodeMember(lhs *Node, name *Token, tok *Token) *Node {
+	typ := nodeType(lhs)
+	if !isAggregate(typ) {
+		errorTok(tok, "Member reference base type is not a structure or union")
+		return newNodePlaceholder(name)
+	}
+	m := findMember(typ, name.str)
+	if m == nil {
+		errorTok(name, "No member named \"%s\"", string(name.str))
+		return newNodePlaceholder(name)
+	}
+	return &Node{
+		kind:   ndMember,
+		tok:    tok,
+		lhs:    lhs,
+		member: m,
+	}
+}
+
+// newNodeCast converts the value of expr to typ.
+func newNodeCast(expr *Node, typ *Type) *Node {
+	return &Node{
+		kind: ndCast,
+		tok:  expr.tok,
+		typ:  typ,
+		lhs:  expr,
+	}
+}
+
+// implicitCast inserts a conversion of a value to typ where C converts it
+// implicitly, which is between arithmetic types and to _Bool.
+func implicitCast(node *Node, typ *Type) *Node {
+	from := nodeType(node)
+	if from == typ {
+		return node
+	}
+	if typ.kind == tyBool && isScalar(from) {
+		return newNodeCast(node, typ)
+	}
+	if !isNumeric(from) || !isNumeric(typ) {
+		return node
+	}
+	if from.kind == typ.kind && from.isUnsigned == typ.isUnsigned {
+		return node
+	}
+	return newNodeCast(node, typ)
+}
+
+// newNodeExplicitCast checks and builds a cast expression "(typ)expr".
+func newNodeExplicitCast(expr *Node, typ *Type, tok *Token) *Node {
+	node := newNodeCast(expr, typ)
+	node.tok = tok
+	if typ.kind == tyVoid {
+		nodeType(expr)
+		return node
+	}
+	from := valueType(expr)
+	switch {
+	case !isScalar(typ):
+		errorTok(tok, "Can not cast to non-scalar type")
+	case !isScalar(from):
+		errorTok(expr.tok, "Can not cast value of non-scalar type")
+	case isFlonum(typ) && !isNumeric(from), isFlonum(from) && !isNumeric(typ):
+		errorTok(tok, "Can not cast between pointer and floating type")
+	}
+	return node
+}
+
+// isConvertible reports whether the value of node can be implicitly
+// converted to typ as by assignment.
+func isConvertible(node *Node, typ *Type) bool {
+	from := nodeType(node)
+	fromPtr := from.kind == tyPtr || from.kind == tyArray
+	switch {
+	case from.kind == tyVoid:
+		// Already reported by valueType
+		return true
+	case isNumeric(typ) && isNumeric(from):
+		return true
+	case typ.kind == tyBool && fromPtr:
+		return true
+	case typ.kind == tyPtr && fromPtr:
+		// void * is converted to and from any other pointer.
+		return typ.ptrTo.kind == tyVoid || from.ptrTo.kind == tyVoid || isCompatible(typ.ptrTo, from.ptrTo)
+	case typ.kind == tyPtr:
+		return isNullPtrConst(node)
+	case isAggregate(typ):
+		return typ == from
+	}
+	return false
+}
+
+// isNullPtrConst reports whether node is an integer constant 0, which is
+// converted to a null pointer.
+func isNullPtrConst(node *Node) bool {
+	switch node.kind {
+	case ndNum:
+		return isInteger(nodeType(node)) && node.val == 0
+	case ndCast:
+		return isInteger(node.typ) && isNullPtrConst(node.lhs)
+	}
+	return false
+}
+
+// convertOperands converts both operands of a binary operator to typ.
+func convertOperands(node *Node, typ *Type) {
+	node.lhs = implicitCast(node.lhs, typ)
+	node.rhs = implicitCast(node.rhs, typ)
+}
+
+func newNodeStr(strLit *StrLit, tok *Token) *Node {
+	return &Node{
+		kind:   ndStr,
+		tok:    tok,
+		strLit: strLit,
+	}
+}
+
+// nodeType returns the type of an expression. Type errors are reported only
+// for the first call since the result is cached in the node.
 func nodeType(node *Node) *Type {
+	if node.typ == nil {
+		node.typ = evalType(node)
+	}
+	return node.typ
+}
+
+func evalType(node *Node) *Type {
 	switch node.kind {
-	case ndEq, ndNe, ndLt, ndLe, ndMul, ndDiv, ndAssign, ndNum:
+	case ndEq, ndNe, ndLt, ndLe:
+		ltype := valueType(node.lhs)
+		rtype := valueType(node.rhs)
+		if isNumeric(ltype) && isNumeric(rtype) {
+			convertOperands(node, usualArithType(ltype, rtype))
+		}
 		return typeInt
-	case ndAdd:
+	case ndLogAnd, ndLogOr:
+		node.lhs = cond(node.lhs)
+		node.rhs = cond(node.rhs)
+		return typeInt
+	case ndNot:
+		node.lhs = cond(node.lhs)
+		return typeInt
+	case ndCond:
+		node.test = cond(node.test)
+		return condType(node)
+	case ndComma:
+		nodeType(node.lhs)
+		return nodeType(node.rhs)
+	case ndNum:
+		return typeInt
+	case ndMul, ndDiv:
+		typ := usualArithType(valueType(node.lhs), valueType(node.rhs))
+		convertOperands(node, typ)
+		return typ
+	case ndMod, ndBitAnd, ndBitOr, ndBitXor:
+		ltype := valueType(node.lhs)
+		rtype := valueType(node.rhs)
+		if !isInteger(ltype) || !isInteger(rtype) {
+			errorTok(node.tok, "Operands of binary %s should be integer type", string(node.tok.str))
+			return typeInt
+		}
+		typ := usualArithType(ltype, rtype)
+		convertOperands(node, typ)
+		return typ
+	case ndShl, ndShr:
+		// Operands are promoted separately and the result has the type of
+		// the left operand.
+		ltype := valueType(node.lhs)
+		rtype := valueType(node.rhs)
+		if !isInteger(ltype) || !isInteger(rtype) {
+			errorTok(node.tok, "Operands of binary %s should be integer type", string(node.tok.str))
+			return typeInt
+		}
+		node.lhs = implicitCast(node.lhs, promote(ltype))
+		node.rhs = implicitCast(node.rhs, promote(rtype))
+		return promote(ltype)
+	case ndNeg:
+		typ := valueType(node.lhs)
+		if !isNumeric(typ) {
+			errorTok(node.tok, "Operand of unary - should be arithmetic type")
+			return typeInt
+		}
+		node.lhs = implicitCast(node.lhs, promote(typ))
+		return promote(typ)
+	case ndBitNot:
+		typ := valueType(node.lhs)
+		if !isInteger(typ) {
+			errorTok(node.tok, "Operand of unary ~ should be integer type")
+			return typeInt
+		}
+		node.lhs = implicitCast(node.lhs, promote(typ))
+		return promote(typ)
+	case ndAssign:
 		ltype := nodeType(node.lhs)
-		rtype := nodeType(node.rhs)
+		valueType(node.rhs)
+		if !isLval(node.lhs) || ltype.kind == tyArray {
+			errorTok(node.lhs.tok, "Expression is not assignable")
+		} else if !isConvertible(node.rhs, ltype) {
+			errorTok(node.rhs.tok, "Incompatible type in assignment")
+		}
+		node.rhs = implicitCast(node.rhs, ltype)
+		return ltype
+	case ndAdd:
+		ltype := valueType(node.lhs)
+		rtype := valueType(node.rhs)
 		lptr := (ltype.kind == tyPtr || ltype.kind == tyArray)
 		rptr := (rtype.kind == tyPtr || rtype.kind == tyArray)
 		if lptr && rptr {
-			fatal("Can not add pointer type value to pointer type value")
+			errorTok(node.tok, "Can not add pointer type value to pointer type value")
+			return typeInt
 		}
 		if lptr {
-			return ltype
+			return typePtrTo(ltype.ptrTo)
 		}
-		return rtype
+		if rptr {
+			return typePtrTo(rtype.ptrTo)
+		}
+		typ := usualArithType(ltype, rtype)
+		convertOperands(node, typ)
+		return typ
 	case ndSub:
-		ltype := nodeType(node.lhs)
-		rtype := nodeType(node.rhs)
+		ltype := valueType(node.lhs)
+		rtype := valueType(node.rhs)
 		lptr := (ltype.kind == tyPtr || ltype.kind == tyArray)
 		rptr := (rtype.kind == tyPtr || rtype.kind == tyArray)
 		if !lptr && rptr {
-			fatal("Can not subtract pointer type value from non-pointer value")
+			errorTok(node.tok, "Can not subtract pointer type value from non-pointer value")
+			return typeInt
 		}
 		if lptr && rptr {
 			return typeInt
 		}
-		return ltype
+		if lptr {
+			return typePtrTo(ltype.ptrTo)
+		}
+		typ := usualArithType(ltype, rtype)
+		convertOperands(node, typ)
+		return typ
 	case ndAddr:
+		if !isLval(node.lhs) {
+			errorTok(node.lhs.tok, "Can not take address of expression")
+		}
 		return typePtrTo(nodeType(node.lhs))
 	case ndDeref:
-		derefNodeType := nodeType(node.lhs)
+		derefNodeType := valueType(node.lhs)
 		if derefNodeType.kind != tyPtr && derefNodeType.kind != tyArray {
-			fatal("Node %+v should be pointer type: %+v", node.lhs, derefNodeType)
+			errorTok(node.tok, "Operand of unary * should be pointer type")
+			return typeInt
+		}
+		if derefNodeType.ptrTo.kind == tyVoid {
+			errorTok(node.tok, "Can not dereference void pointer")
+			return typeInt
 		}
 		return derefNodeType.ptrTo
+	case ndMember:
+		return node.member.typ
+	case ndVaStart:
+		return typeVoid
 	case ndFcall:
-		return typeInt
+		for i, arg := range node.args {
+			typ := valueType(arg)
+			if node.funcType != nil && i < len(node.funcType.params) {
+				param := node.funcType.params[i]
+				if !isConvertible(arg, param) {
+					errorTok(arg.tok, "Incompatible type for argument %d of \"%s\"", i+1, node.funcName)
+				}
+				node.args[i] = implicitCast(arg, param)
+			} else if isAggregate(typ) {
+				errorTok(arg.tok, "Passing struct or union by value is not supported")
+			} else if typ.kind == tyFloat {
+				// Default argument promotions
+				node.args[i] = implicitCast(arg, typeDouble)
+			} else if isInteger(typ) {
+				node.args[i] = implicitCast(arg, promote(typ))
+			}
+		}
+		if node.funcType == nil {
+			// Implicitly declared function
+			return typeInt
+		}
+		return node.funcType.retTyp
 	case ndVar:
 		return node.vble.typ
+	case ndStr:
+		return typeArray(typeChar, len(node.strLit.contents))
 	default:
-		fatal("Node %+v don't have type", node)
-		return nil
+		errorTok(node.tok, "Expression don't have type")
+		return typeInt
 	}
 }
 
-func program() []*Function {
-	envGlobal = newEnv()
-	var funcs []*Function
-	for !atEOF() {
-		f := toplv()
-		if f != nil {
-			funcs = append(funcs, f)
+// condType returns the type of a conditional expression, which is the
+// common type of both arms.
+func condType(node *Node) *Type {
+	ltype := nodeType(node.cons)
+	rtype := nodeType(node.alt)
+	lptr := (ltype.kind == tyPtr || ltype.kind == tyArray)
+	rptr := (rtype.kind == tyPtr || rtype.kind == tyArray)
+	switch {
+	case ltype.kind == tyVoid && rtype.kind == tyVoid:
+		return typeVoid
+	case isNumeric(ltype) && isNumeric(rtype):
+		typ := usualArithType(ltype, rtype)
+		node.cons = implicitCast(node.cons, typ)
+		node.alt = implicitCast(node.alt, typ)
+		return typ
+	case lptr && rptr:
+		if ltype.ptrTo.kind == tyVoid || rtype.ptrTo.kind == tyVoid {
+			return typePtrTo(typeVoid)
+		}
+		if !isCompatible(ltype.ptrTo, rtype.ptrTo) {
+			errorTok(node.tok, "Pointer type mismatch in conditional expression")
 		}
+		return typePtrTo(ltype.ptrTo)
+	case lptr && isInteger(rtype):
+		// The integer is a null pointer constant.
+		return typePtrTo(ltype.ptrTo)
+	case rptr && isInteger(ltype):
+		return typePtrTo(rtype.ptrTo)
+	case isAggregate(ltype) && ltype == rtype:
+		return ltype
 	}
-	return funcs
+	errorTok(node.tok, "Incompatible operand types in conditional expression")
+	return typeInt
 }
 
-func toplv() *Function {
-	topTyp := typ()
-	name := expectKind(tkIdent)
+// valueType returns the type of an expression whose value is used, which
+// can not be void.
+func valueType(node *Node) *Type {
+	typ := nodeType(node)
+	if typ.kind == tyVoid {
+		errorTok(node.tok, "Value of void expression can not be used")
+		return typeInt
+	}
+	return typ
+}
 
-	if consume("(") {
-		env := newEnv()
-		var params []*Var
-		firstParam := true
-		for !consume(")") {
-			if firstParam {
-				firstParam = false
-			} else {
-				expect(",")
-			}
-			paramTyp := typ()
-			ident := expectKind(tkIdent)
-			params = append(params, newLocalVar(paramTyp, ident.str))
-		}
+// evalIntmax is set while #if is evaluated. All integers in #if are computed
+// as intmax_t or uintmax_t, so values are not wrapped to narrower types.
+var evalIntmax bool
 
-		expect("{")
-		var stmts []*Node
-		for !consume("}") {
-			stmts = append(stmts, stmt())
-		}
-		body := newNodeBlock(stmts)
+// eval evaluates a constant expression. Each value is wrapped around to the
+// width of its type.
+func eval(node *Node) int {
+	return wrapValue(evalValue(node), nodeType(node))
+}
 
-		return &Function{
-			name:   name.str,
-			env:    env,
-			params: params,
-			body:   body,
+func evalValue(node *Node) int {
+	switch node.kind {
+	case ndAdd:
+		return eval(node.lhs) + eval(node.rhs)
+	case ndSub:
+		return eval(node.lhs) - eval(node.rhs)
+	case ndMul:
+		return eval(node.lhs) * eval(node.rhs)
+	case ndDiv:
+		rhs := eval(node.rhs)
+		if rhs == 0 {
+			errorTok(node.tok, "Division by zero in constant expression")
+			return 0
+		}
+		if nodeType(node).isUnsigned {
+			return int(uint64(eval(node.lhs)) / uint64(rhs))
+		}
+		return eval(node.lhs) / rhs
+	case ndMod:
+		rhs := eval(node.rhs)
+		if rhs == 0 {
+			errorTok(node.tok, "Division by zero in constant expression")
+			return 0
 		}
+		if nodeType(node).isUnsigned {
+			return int(uint64(eval(node.lhs)) % uint64(rhs))
+		}
+		return eval(node.lhs) % rhs
+	case ndBitAnd:
+		return eval(node.lhs) & eval(node.rhs)
+	case ndBitOr:
+		return eval(node.lhs) | eval(node.rhs)
+	case ndBitXor:
+		return eval(node.lhs) ^ eval(node.rhs)
+	case ndBitNot:
+		return ^eval(node.lhs)
+	case ndNeg:
+		return -eval(node.lhs)
+	case ndShl:
+		return eval(node.lhs) << uint(eval(node.rhs))
+	case ndShr:
+		if nodeType(node).isUnsigned {
+			return int(uint64(eval(node.lhs)) >> uint(eval(node.rhs)))
+		}
+		return eval(node.lhs) >> uint(eval(node.rhs))
+	case ndEq:
+		return boolToInt(eval(node.lhs) == eval(node.rhs))
+	case ndNe:
+		return boolToInt(eval(node.lhs) != eval(node.rhs))
+	case ndLt:
+		if nodeType(node.lhs).isUnsigned {
+			return boolToInt(uint64(eval(node.lhs)) < uint64(eval(node.rhs)))
+		}
+		return boolToInt(eval(node.lhs) < eval(node.rhs))
+	case ndLogAnd:
+		return boolToInt(eval(node.lhs) != 0 && eval(node.rhs) != 0)
+	case ndLogOr:
+		return boolToInt(eval(node.lhs) != 0 || eval(node.rhs) != 0)
+	case ndNot:
+		return boolToInt(eval(node.lhs) == 0)
+	case ndCond:
+		if eval(node.test) != 0 {
+			return eval(node.cons)
+		}
+		return eval(node.alt)
+	case ndLe:
+		if nodeType(node.lhs).isUnsigned {
+			return boolToInt(uint64(eval(node.lhs)) <= uint64(eval(node.rhs)))
+		}
+		return boolToInt(eval(node.lhs) <= eval(node.rhs))
+	case ndNum:
+		if isFlonum(nodeType(node)) {
+			return int(node.fval)
+		}
+		return node.val
+	case ndCast:
+		// The value is converted by eval.
+		return eval(node.lhs)
 	}
+	errorTok(node.tok, "Expression is not a compile-time constant")
+	return 0
+}
 
-	for consume("[") {
-		count := expectNumber()
-		expect("]")
-		topTyp = typeArray(topTyp, count)
+// wrapValue converts an integer value to typ, wrapping it around to the
+// width of typ as registers do at runtime.
+func wrapValue(val int, typ *Type) int {
+	if !isInteger(typ) || (evalIntmax && typ.kind != tyBool) {
+		return val
 	}
-	newGlobalVar(topTyp, name.str)
-	expect(";")
+	switch {
+	case typ.kind == tyBool:
+		return boolToInt(val != 0)
+	case typ.size == 1 && typ.isUnsigned:
+		return int(uint8(val))
+	case typ.size == 1:
+		return int(int8(val))
+	case typ.size == 2 && typ.isUnsigned:
+		return int(uint16(val))
+	case typ.size == 2:
+		return int(int16(val))
+	case typ.size == 4 && typ.isUnsigned:
+		return int(uint32(val))
+	case typ.size == 4:
+		return int(int32(val))
+	}
+	return val
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isLval(node *Node) bool {
+	switch node.kind {
+	case ndVar, ndDeref, ndMember, ndStr:
+		return true
+	}
+	return false
+}
+
+// addTypes computes types of all expressions in the tree so that type
+// errors are reported while parsing rather than in code generation.
+func addTypes(node *Node) {
+	if node == nil {
+		return
+	}
+	for _, n := range []*Node{node.lhs, node.rhs, node.test, node.cons, node.alt, node.init, node.post} {
+		addTypes(n)
+	}
+	for _, n := range node.body {
+		addTypes(n)
+	}
+	for _, n := range node.args {
+		addTypes(n)
+	}
+
+	switch node.kind {
+	case ndIf, ndWhile, ndFor, ndBlock, ndReturn, ndNull:
+		return
+	}
+	nodeType(node)
+}
+
+// recoverSyntaxError recovers from a panic raised by syntaxErrorTok and
+// skips tokens until the end of the broken statement, or the broken
+// declaration if topLevel.
+func recoverSyntaxError(r interface{}, topLevel bool) {
+	if _, ok := r.(syntaxError); !ok {
+		panic(r)
+	}
+	if atEOF() && !topLevel {
+		// Unwind to toplv() since the enclosing block will never be closed.
+		panic(r)
+	}
+
+	depth := 0
+	for !atEOF() {
+		if peek("{") {
+			depth++
+		} else if peek("}") {
+			if depth == 0 && !topLevel {
+				return
+			}
+			depth--
+			if depth <= 0 {
+				token = token.next
+				return
+			}
+		} else if peek(";") && depth == 0 {
+			token = token.next
+			return
+		}
+		token = token.next
+	}
+}
+
+// Program is a translation unit.
+type Program struct {
+	funcs   []*Function
+	globals *Env
+}
+
+// program parses a translation unit. Each translation unit has its own
+// global scope.
+func program() *Program {
+	envGlobal = newEnv()
+	envGlobal.vars["va_list"] = &Var{typ: typeVaList, name: []rune("va_list"), isTypedef: true}
+	var funcs []*Function
+	for !atEOF() {
+		f := toplv()
+		if f != nil {
+			funcs = append(funcs, f)
+		}
+	}
+	return &Program{funcs: funcs, globals: envGlobal}
+}
+
+// checkLinkage reports variables and functions defined in more than one
+// translation unit.
+func checkLinkage(progs []*Program) {
+	defined := make(map[string]bool)
+	for _, prog := range progs {
+		for name, v := range prog.globals.vars {
+			if !hasStorage(v) && !v.isDefined {
+				continue
+			}
+			if defined[name] {
+				errorTok(v.tok, "\"%s\" is already defined in another file", name)
+			}
+			defined[name] = true
+		}
+	}
+}
+
+func toplv() (f *Function) {
+	defer func() {
+		if r := recover(); r != nil {
+			recoverSyntaxError(r, true)
+			f = nil
+		}
+	}()
+
+	env = envGlobal
+	isTypedef := false
+	baseTyp := declspec(&isTypedef)
+	if consume(";") {
+		// Only struct, union or enum declaration
+		return nil
+	}
+	topTyp, name := declarator(baseTyp)
+
+	if !isTypedef && consume("(") {
+		return funcDecl(topTyp, name)
+	}
+
+	for {
+		if isTypedef {
+			newTypedef(topTyp, name)
+		} else {
+			checkComplete(topTyp, name)
+			newGlobalVar(topTyp, name)
+		}
+		if !consume(",") {
+			break
+		}
+		topTyp, name = declarator(baseTyp)
+	}
+	expect(";")
 
 	return nil
 }
 
-func stmt() *Node {
-	var node *Node
+// funcDecl parses the rest of a function prototype or definition after the
+// opening parenthesis of its parameter list.
+func funcDecl(retTyp *Type, name *Token) *Function {
+	if isAggregate(retTyp) {
+		errorTok(name, "Returning struct or union by value is not supported")
+	}
+	env := newEnv()
+	var paramTypes []*Type
+	var paramNames []*Token
+	noProto := peek(")")
+	if peek("void") && equal(token.next, ")") {
+		// No parameters
+		token = token.next
+	}
+	isVariadic := false
+	for !consume(")") {
+		if len(paramTypes) > 0 {
+			expect(",")
+		}
+		if consume("...") {
+			isVariadic = true
+			expect(")")
+			break
+		}
+		tok := token
+		paramTyp, ident := paramDeclarator(declspec(nil))
+		if isAggregate(paramTyp) {
+			errorTok(tok, "Passing struct or union by value is not supported")
+		}
+		if paramTyp.kind == tyArray {
+			// Array parameters are adjusted to pointers.
+			paramTyp = typePtrTo(paramTyp.ptrTo)
+		}
+		paramTypes = append(paramTypes, paramTyp)
+		paramNames = append(paramNames, ident)
+	}
+
+	fnTyp := typeFunc(retTyp, paramTypes)
+	fnTyp.noProto = noProto
+	fnTyp.isVariadic = isVariadic
+	fn := declareFunc(fnTyp, name)
+	if consume(";") {
+		// Prototype
+		return nil
+	}
+
+	if fn.isDefined {
+		errorTok(name, "Redefinition of function \"%s\"", string(name.str))
+	}
+	fn.isDefined = true
+	var params []*Var
+	for i, typ := range paramTypes {
+		if paramNames[i] == nil {
+			errorTok(name, "Parameter name omitted in definition of \"%s\"", string(name.str))
+			continue
+		}
+		checkComplete(typ, paramNames[i])
+		params = append(params, newLocalVar(typ, paramNames[i]))
+	}
+	var vaArea *Var
+	if isVariadic {
+		// 6 general purpose registers and 8 xmm registers
+		size := 6*8 + 8*16
+		vaArea = &Var{
+			typ:    typeArray(typeChar, size),
+			name:   []rune("__va_area__"),
+			tok:    name,
+			offset: alignTo(env.maxOffset+size, 16),
+		}
+		env.maxOffset = vaArea.offset
+	}
+	currentFunc = fn
+
+	tok := token
+	expect("{")
+	var stmts []*Node
+	for !consume("}") {
+		stmts = append(stmts, stmt())
+	}
+	body := newNodeBlock(stmts, tok)
+
+	return &Function{
+		name:   name.str,
+		env:    env,
+		params: params,
+		body:   body,
+		vaArea: vaArea,
+	}
+}
+
+// declareFunc adds a function to the function symbol table, which is the
+// global scope. Declarations of the same function must be compatible.
+func declareFunc(typ *Type, name *Token) *Var {
+	fn := envGlobal.vars[string(name.str)]
+	if fn == nil {
+		return newGlobalVar(typ, name)
+	}
+	if fn.typ.kind != tyFunc {
+		errorTok(name, "Redefinition of \"%s\" as different kind of symbol", string(name.str))
+		// Parse the function with a symbol which is not registered.
+		return &Var{
+			typ:      typ,
+			name:     name.str,
+			tok:      name,
+			isGlobal: true,
+		}
+	}
+	if !isCompatible(fn.typ, typ) {
+		errorTok(name, "Conflicting types for \"%s\"", string(name.str))
+		return fn
+	}
+	if fn.typ.noProto {
+		// Parameters become known by the prototype.
+		fn.typ = typ
+	}
+	return fn
+}
+
+func stmt() (node *Node) {
+	defer func() {
+		if r := recover(); r != nil {
+			recoverSyntaxError(r, false)
+			node = nullNode
+		}
+	}()
+
+	tok := token
 	if consume("if") {
 		expect("(")
-		test := expr()
+		test := condition()
 		expect(")")
 		cons := stmt()
 		var alt *Node
 		if consume("else") {
 			alt = stmt()
 		}
-		node = newNodeIf(test, cons, alt)
+		node = newNodeIf(test, cons, alt, tok)
 	} else if consume("while") {
 		expect("(")
-		test := expr()
+		test := condition()
 		expect(")")
 		cons := stmt()
-		node = newNodeWhile(test, cons)
+		node = newNodeWhile(test, cons, tok)
 	} else if consume("for") {
 		var init, test, post *Node
 		expect("(")
@@ -356,7 +1404,7 @@ func stmt() *Node {
 			expect(";")
 		}
 		if !consume(";") {
-			test = expr()
+			test = condition()
 			expect(";")
 		}
 		if !consume(")") {
@@ -364,27 +1412,35 @@ func stmt() *Node {
 			expect(")")
 		}
 		cons := stmt()
-		node = newNodeFor(init, test, post, cons)
+		node = newNodeFor(init, test, post, cons, tok)
 	} else if consume("return") {
-		node = newNode(ndReturn, expr(), nil)
-		expect(";")
-	} else if peekTyp() {
-		typ := typ()
-		ident := expectKind(tkIdent)
-		for consume("[") {
-			count := expectNumber()
-			expect("]")
-			typ = typeArray(typ, count)
+		retTyp := currentFunc.typ.retTyp
+		if consume(";") {
+			if retTyp.kind != tyVoid {
+				errorTok(tok, "Non-void function \"%s\" should return a value", string(currentFunc.name))
+			}
+			return newNode(ndReturn, nil, nil, tok)
+		}
+		node = newNode(ndReturn, expr(), nil, tok)
+		if retTyp.kind == tyVoid {
+			errorTok(node.lhs.tok, "Void function \"%s\" should not return a value", string(currentFunc.name))
+		} else {
+			valueType(node.lhs)
+			if !isConvertible(node.lhs, retTyp) {
+				errorTok(node.lhs.tok, "Incompatible type of return value")
+			}
+			node.lhs = implicitCast(node.lhs, retTyp)
 		}
-		newLocalVar(typ, ident.str)
 		expect(";")
+	} else if isTypename(token) {
+		declaration()
 		node = nullNode
 	} else if consume("{") {
 		var body []*Node
 		for !consume("}") {
 			body = append(body, stmt())
 		}
-		node = newNodeBlock(body)
+		node = newNodeBlock(body, tok)
 	} else {
 		node = expr()
 		expect(";")
@@ -392,27 +1448,184 @@ func stmt() *Node {
 	return node
 }
 
+// declaration parses a local declaration, which defines variables or
+// typedef names.
+func declaration() {
+	isTypedef := false
+	baseTyp := declspec(&isTypedef)
+	if consume(";") {
+		return
+	}
+	for {
+		typ, ident := declarator(baseTyp)
+		if isTypedef {
+			newTypedef(typ, ident)
+		} else {
+			checkComplete(typ, ident)
+			newLocalVar(typ, ident)
+		}
+		if !consume(",") {
+			break
+		}
+	}
+	expect(";")
+}
+
+// condition parses the controlling expression of if, while and for.
+func condition() *Node {
+	return cond(expr())
+}
+
+// cond makes a scalar value comparable with 0 by integer instructions.
+func cond(node *Node) *Node {
+	if isFlonum(valueType(node)) {
+		// Compare with 0.0 since the bit pattern of -0.0 is not zero.
+		node = newNode(ndNe, node, newNodeNum(0, node.tok), node.tok)
+		addTypes(node)
+	}
+	return node
+}
+
 func expr() *Node {
-	return assign()
+	node := assign()
+
+	for {
+		tok := token
+		if consume(",") {
+			node = newNode(ndComma, node, assign(), tok)
+		} else {
+			addTypes(node)
+			return node
+		}
+	}
+}
+
+// constExpr parses and evaluates an integer constant expression.
+func constExpr() int {
+	node := conditional()
+	addTypes(node)
+	return eval(node)
+}
+
+// compoundAssignOps maps compound assignment operators to their binary
+// operators.
+var compoundAssignOps = []struct {
+	op   string
+	kind NodeKind
+}{
+	{"+=", ndAdd},
+	{"-=", ndSub},
+	{"*=", ndMul},
+	{"/=", ndDiv},
+	{"%=", ndMod},
+	{"&=", ndBitAnd},
+	{"|=", ndBitOr},
+	{"^=", ndBitXor},
+	{"<<=", ndShl},
+	{">>=", ndShr},
 }
 
 func assign() *Node {
-	node := equality()
+	node := conditional()
 
+	tok := token
 	if consume("=") {
-		node = newNode(ndAssign, node, assign())
+		return newNode(ndAssign, node, assign(), tok)
+	}
+	for _, c := range compoundAssignOps {
+		if consume(c.op) {
+			return newNodeAssignOp(c.kind, node, assign(), tok)
+		}
+	}
+	return node
+}
+
+func conditional() *Node {
+	node := logOr()
+
+	tok := token
+	if consume("?") {
+		cons := expr()
+		expect(":")
+		return newNodeCond(node, cons, conditional(), tok)
 	}
 	return node
 }
 
+func logOr() *Node {
+	node := logAnd()
+
+	for {
+		tok := token
+		if consume("||") {
+			node = newNode(ndLogOr, node, logAnd(), tok)
+		} else {
+			return node
+		}
+	}
+}
+
+func logAnd() *Node {
+	node := bitOr()
+
+	for {
+		tok := token
+		if consume("&&") {
+			node = newNode(ndLogAnd, node, bitOr(), tok)
+		} else {
+			return node
+		}
+	}
+}
+
+func bitOr() *Node {
+	node := bitXor()
+
+	for {
+		tok := token
+		if consume("|") {
+			node = newNode(ndBitOr, node, bitXor(), tok)
+		} else {
+			return node
+		}
+	}
+}
+
+func bitXor() *Node {
+	node := bitAnd()
+
+	for {
+		tok := token
+		if consume("^") {
+			node = newNode(ndBitXor, node, bitAnd(), tok)
+		} else {
+			return node
+		}
+	}
+}
+
+func bitAnd() *Node {
+	node := equality()
+
+	for {
+		tok := token
+		if consume("&") {
+			node = newNode(ndBitAnd, node, equality(), tok)
+		} else {
+			return node
+		}
+	}
+}
+
 func equality() *Node {
 	node := relational()
 
 	for {
+		tok := token
 		if consume("==") {
-			node = newNode(ndEq, node, relational())
+			node = newNode(ndEq, node, relational(), tok)
 		} else if consume("!=") {
-			node = newNode(ndNe, node, relational())
+			node = newNode(ndNe, node, relational(), tok)
 		} else {
 			return node
 		}
@@ -420,17 +1633,33 @@ func equality() *Node {
 }
 
 func relational() *Node {
-	node := add()
+	node := shift()
 
 	for {
+		tok := token
 		if consume("<") {
-			node = newNode(ndLt, node, add())
+			node = newNode(ndLt, node, shift(), tok)
 		} else if consume("<=") {
-			node = newNode(ndLe, node, add())
+			node = newNode(ndLe, node, shift(), tok)
 		} else if consume(">") {
-			node = newNode(ndLt, add(), node)
+			node = newNode(ndLt, shift(), node, tok)
 		} else if consume(">=") {
-			node = newNode(ndLe, add(), node)
+			node = newNode(ndLe, shift(), node, tok)
+		} else {
+			return node
+		}
+	}
+}
+
+func shift() *Node {
+	node := add()
+
+	for {
+		tok := token
+		if consume("<<") {
+			node = newNode(ndShl, node, add(), tok)
+		} else if consume(">>") {
+			node = newNode(ndShr, node, add(), tok)
 		} else {
 			return node
 		}
@@ -441,10 +1670,11 @@ func add() *Node {
 	node := mul()
 
 	for {
+		tok := token
 		if consume("+") {
-			node = newNode(ndAdd, node, mul())
+			node = newNode(ndAdd, node, mul(), tok)
 		} else if consume("-") {
-			node = newNode(ndSub, node, mul())
+			node = newNode(ndSub, node, mul(), tok)
 		} else {
 			return node
 		}
@@ -452,43 +1682,99 @@ func add() *Node {
 }
 
 func mul() *Node {
-	node := unary()
+	node := cast()
 
 	for {
+		tok := token
 		if consume("*") {
-			node = newNode(ndMul, node, unary())
+			node = newNode(ndMul, node, cast(), tok)
 		} else if consume("/") {
-			node = newNode(ndDiv, node, unary())
+			node = newNode(ndDiv, node, cast(), tok)
+		} else if consume("%") {
+			node = newNode(ndMod, node, cast(), tok)
 		} else {
 			return node
 		}
 	}
 }
 
+// cast parses a cast expression. "(" is a cast if a type name follows it,
+// and a parenthesized expression otherwise.
+func cast() *Node {
+	tok := token
+	if peek("(") && isTypename(token.next) {
+		expect("(")
+		typ := typeName()
+		expect(")")
+		return newNodeExplicitCast(cast(), typ, tok)
+	}
+	return unary()
+}
+
 func unary() *Node {
+	tok := token
 	if consume("+") {
-		return primary()
+		return cast()
 	}
 	if consume("-") {
-		return newNode(ndSub, newNodeNum(0), primary())
+		return newNode(ndNeg, cast(), nil, tok)
 	}
 	if consume("&") {
-		return newNode(ndAddr, unary(), nil)
+		return newNode(ndAddr, cast(), nil, tok)
 	}
 	if consume("*") {
-		return newNode(ndDeref, unary(), nil)
+		return newNode(ndDeref, cast(), nil, tok)
+	}
+	if consume("!") {
+		return newNode(ndNot, cast(), nil, tok)
+	}
+	if consume("++") {
+		return newNodeAssignOp(ndAdd, unary(), newNodeNum(1, tok), tok)
+	}
+	if consume("--") {
+		return newNodeAssignOp(ndSub, unary(), newNodeNum(1, tok), tok)
+	}
+	if consume("~") {
+		return newNode(ndBitNot, cast(), nil, tok)
 	}
 	if consume("sizeof") {
+		if peek("(") && isTypename(token.next) {
+			expect("(")
+			typ := typeName()
+			expect(")")
+			checkComplete(typ, tok)
+			return newNodeNum(typ.size, tok)
+		}
 		node := unary()
-		return newNodeNum(nodeType(node).size)
+		typ := nodeType(node)
+		checkComplete(typ, tok)
+		return newNodeNum(typ.size, tok)
 	}
 
+	return postfix()
+}
+
+func postfix() *Node {
 	node := primary()
-	if consume("[") {
-		node = newNode(ndDeref, newNode(ndAdd, node, expr()), nil)
-		expect("]")
+
+	for {
+		tok := token
+		if consume("[") {
+			node = newNode(ndDeref, newNode(ndAdd, node, expr(), tok), nil, tok)
+			expect("]")
+		} else if consume(".") {
+			node = newNodeMember(node, expectKind(tkIdent), tok)
+		} else if consume("->") {
+			node = newNode(ndDeref, node, nil, tok)
+			node = newNodeMember(node, expectKind(tkIdent), tok)
+		} else if consume("++") {
+			node = newNodePostIncDec(node, 1, tok)
+		} else if consume("--") {
+			node = newNodePostIncDec(node, -1, tok)
+		} else {
+			return node
+		}
 	}
-	return node
 }
 
 func primary() *Node {
@@ -498,8 +1784,13 @@ func primary() *Node {
 		return node
 	}
 
-	token := consumeKind(tkIdent)
-	if token != nil {
+	ident := consumeKind(tkIdent)
+	if ident != nil {
+		if peek("(") && findVar(ident.str) == nil {
+			if node := builtin(ident); node != nil {
+				return node
+			}
+		}
 		if consume("(") {
 			var args []*Node
 			firstArg := true
@@ -509,36 +1800,428 @@ func primary() *Node {
 				} else {
 					expect(",")
 				}
-				args = append(args, expr())
+				args = append(args, assign())
 			}
-			return newNodeFcall(token.str, args)
+			return newNodeFcall(ident, args)
+		}
+		return newNodeVar(ident)
+	}
+
+	tok := token
+	if str := consumeKind(tkStr); str != nil {
+		// Adjacent string literals are concatenated.
+		contents := append([]byte{}, str.contents...)
+		for token.kind == tkStr {
+			contents = append(contents, token.contents...)
+			token = token.next
 		}
-		return newNodeVar(token.str)
+		return newNodeStr(newStrLit(contents), tok)
 	}
 
-	return newNodeNum(expectNumber())
+	node := newNodeNum(expectNumber(), tok)
+	node.typ = tok.typ
+	node.fval = tok.fval
+	return node
 }
 
-func typ() *Type {
-	token := expectKind(tkReserved)
+// Type specifier keywords are counted to validate their combinations, like
+// "unsigned long long int". Each count has 2 bits of room.
+const (
+	specVoid     = 1 << 0
+	specBool     = 1 << 2
+	specChar     = 1 << 4
+	specShort    = 1 << 6
+	specInt      = 1 << 8
+	specLong     = 1 << 10
+	specFloat    = 1 << 12
+	specDouble   = 1 << 14
+	specOther    = 1 << 16
+	specSigned   = 1 << 17
+	specUnsigned = 1 << 18
+)
+
+// declspec parses declaration specifiers: a storage class and type
+// specifiers. isTypedef is nil where a storage class is not allowed.
+func declspec(isTypedef *bool) *Type {
 	var typ *Type
-	if token != nil {
-		switch string(token.str) {
-		case "int":
-			typ = typeInt
+	counter := 0
+	for isTypename(token) {
+		tok := token
+		if consume("typedef") {
+			if isTypedef == nil {
+				errorTok(tok, "Storage class specifier is not allowed here")
+			} else {
+				*isTypedef = true
+			}
+			continue
+		}
+		if counter != 0 && tok.kind == tkIdent {
+			// A typedef name after a type specifier is the declared name.
+			break
+		}
+
+		token = token.next
+		if tok.kind == tkIdent || equal(tok, "struct") || equal(tok, "union") || equal(tok, "enum") {
+			if counter != 0 {
+				syntaxErrorTok(tok, "Cannot combine with previous type specifier")
+			}
+			counter += specOther
+			switch string(tok.str) {
+			case "struct":
+				typ = structDecl(tyStruct)
+			case "union":
+				typ = structDecl(tyUnion)
+			case "enum":
+				typ = enumDecl()
+			default:
+				// Typedef name
+				typ = findVar(tok.str).typ
+			}
+			continue
+		}
+
+		switch string(tok.str) {
+		case "void":
+			counter += specVoid
+		case "_Bool":
+			counter += specBool
 		case "char":
+			counter += specChar
+		case "short":
+			counter += specShort
+		case "int":
+			counter += specInt
+		case "long":
+			counter += specLong
+		case "float":
+			counter += specFloat
+		case "double":
+			counter += specDouble
+		case "signed":
+			counter |= specSigned
+		case "unsigned":
+			counter |= specUnsigned
+		}
+
+		switch counter {
+		case specVoid:
+			typ = typeVoid
+		case specBool:
+			typ = typeBool
+		case specChar, specSigned + specChar:
 			typ = typeChar
+		case specUnsigned + specChar:
+			typ = typeUChar
+		case specShort, specShort + specInt,
+			specSigned + specShort, specSigned + specShort + specInt:
+			typ = typeShort
+		case specUnsigned + specShort, specUnsigned + specShort + specInt:
+			typ = typeUShort
+		case specInt, specSigned, specSigned + specInt:
+			typ = typeInt
+		case specUnsigned, specUnsigned + specInt:
+			typ = typeUInt
+		case specLong, specLong + specInt, specLong + specLong, specLong + specLong + specInt,
+			specSigned + specLong, specSigned + specLong + specInt,
+			specSigned + specLong + specLong, specSigned + specLong + specLong + specInt:
+			typ = typeLong
+		case specUnsigned + specLong, specUnsigned + specLong + specInt,
+			specUnsigned + specLong + specLong, specUnsigned + specLong + specLong + specInt:
+			typ = typeULong
+		case specFloat:
+			typ = typeFloat
+		case specDouble, specLong + specDouble:
+			// long double is treated as double.
+			typ = typeDouble
+		default:
+			syntaxErrorTok(tok, "Cannot combine with previous type specifier")
 		}
 	}
 	if typ == nil {
-		fatal("Expect type name but \"%s\" is unknown type name", string(token.str))
+		syntaxErrorTok(token, "Expect type name but \"%s\" is unknown type name", string(token.str))
 	}
+	return typ
+}
+
+// declarator parses pointers, a declared name and array dimensions, which
+// are applied to typ in the C declaration order.
+func declarator(typ *Type) (*Type, *Token) {
 	for consume("*") {
 		typ = typePtrTo(typ)
 	}
-	return typ
+	if consume("(") {
+		// The suffix after the parenthesized declarator applies first, as
+		// in "int (*p)[3]".
+		start := token
+		declarator(&Type{})
+		expect(")")
+		typ = typeSuffix(typ)
+		end := token
+		token = start
+		typ, name := declarator(typ)
+		token = end
+		return typ, name
+	}
+	name := expectKind(tkIdent)
+	return typeSuffix(typ), name
 }
 
-func peekTyp() bool {
-	return peek("int") || peek("char")
+// abstractDeclarator is a declarator without a declared name.
+func abstractDeclarator(typ *Type) *Type {
+	for consume("*") {
+		typ = typePtrTo(typ)
+	}
+	if consume("(") {
+		start := token
+		abstractDeclarator(&Type{})
+		expect(")")
+		typ = typeSuffix(typ)
+		end := token
+		token = start
+		typ = abstractDeclarator(typ)
+		token = end
+		return typ
+	}
+	return typeSuffix(typ)
+}
+
+// paramDeclarator parses a declarator of a parameter, whose name can be
+// omitted. The returned name is nil if omitted.
+func paramDeclarator(typ *Type) (*Type, *Token) {
+	start := token
+	for consume("*") {
+	}
+	isAbstract := token.kind != tkIdent && !peek("(")
+	token = start
+	if isAbstract {
+		return abstractDeclarator(typ), nil
+	}
+	return declarator(typ)
+}
+
+// typeName parses a type name in sizeof and casts.
+func typeName() *Type {
+	return abstractDeclarator(declspec(nil))
+}
+
+// typeSuffix parses array dimensions following a declared name.
+func typeSuffix(typ *Type) *Type {
+	if !consume("[") {
+		return typ
+	}
+	tok := token
+	count := constExpr()
+	if count < 0 {
+		errorTok(tok, "Array size is negative")
+		count = 0
+	}
+	expect("]")
+	typ = typeSuffix(typ)
+	return typeArray(typ, count)
+}
+
+// structDecl parses a struct or union specifier after "struct" or "union".
+func structDecl(kind TypeKind) *Type {
+	tag := consumeKind(tkIdent)
+	if tag != nil && !peek("{") {
+		st := findTag(tag.str)
+		if st == nil {
+			// Declare an incomplete struct, which can be used via pointers.
+			st = &Type{kind: kind, align: 1, isIncomplete: true}
+			env.tags[string(tag.str)] = st
+		} else if st.kind != kind {
+			errorTok(tag, "Use of \"%s\" with tag type that does not match previous declaration", string(tag.str))
+		}
+		return st
+	}
+
+	st := &Type{kind: kind, align: 1}
+	if tag != nil {
+		// Complete the struct declared in the same scope, which can be
+		// referenced by its members.
+		if t := env.tags[string(tag.str)]; t != nil {
+			if !t.isIncomplete || t.kind != kind {
+				errorTok(tag, "Redefinition of \"%s\"", string(tag.str))
+			} else {
+				st = t
+			}
+		}
+		env.tags[string(tag.str)] = st
+	}
+
+	expect("{")
+	var members []*Member
+	for !consume("}") {
+		baseTyp := declspec(nil)
+		if isAggregate(baseTyp) && peek(";") {
+			// Anonymous struct or union member
+			for _, m := range baseTyp.members {
+				if m.name != nil && findMember(&Type{members: members}, m.name) != nil {
+					errorTok(m.tok, "Duplicate member \"%s\"", string(m.name))
+				}
+			}
+			checkComplete(baseTyp, token)
+			members = append(members, &Member{typ: baseTyp, tok: token})
+			expect(";")
+			continue
+		}
+		for {
+			memTyp, name := declarator(baseTyp)
+			checkComplete(memTyp, name)
+			if findMember(&Type{members: members}, name.str) != nil {
+				errorTok(name, "Duplicate member \"%s\"", string(name.str))
+			}
+			members = append(members, &Member{name: name.str, typ: memTyp, tok: name})
+			if !consume(",") {
+				break
+			}
+		}
+		expect(";")
+	}
+
+	offset := 0
+	for _, m := range members {
+		if kind == tyStruct {
+			m.offset = alignTo(offset, m.typ.align)
+			offset = m.offset + m.typ.size
+		} else if offset < m.typ.size {
+			// All members of union start at offset 0, so its size is the
+			// largest member size.
+			offset = m.typ.size
+		}
+		if st.align < m.typ.align {
+			st.align = m.typ.align
+		}
+	}
+	st.members = members
+	st.size = alignTo(offset, st.align)
+	st.isIncomplete = false
+	return st
+}
+
+// enumDecl parses an enum specifier after "enum".
+func enumDecl() *Type {
+	tag := consumeKind(tkIdent)
+	if tag != nil && !peek("{") {
+		et := findTag(tag.str)
+		if et == nil {
+			errorTok(tag, "Use of undeclared enum \"%s\"", string(tag.str))
+			return typeInt
+		}
+		if et.kind != tyEnum {
+			errorTok(tag, "Use of \"%s\" with tag type that does not match previous declaration", string(tag.str))
+		}
+		return et
+	}
+
+	et := &Type{kind: tyEnum, size: 4, align: 4}
+	if tag != nil {
+		if _, exist := env.tags[string(tag.str)]; exist {
+			errorTok(tag, "Redefinition of \"%s\"", string(tag.str))
+		}
+		env.tags[string(tag.str)] = et
+	}
+
+	expect("{")
+	val := 0
+	for !consume("}") {
+		name := expectKind(tkIdent)
+		if consume("=") {
+			val = constExpr()
+		}
+		newEnumConst(val, name)
+		val++
+		if !peek("}") {
+			expect(",")
+		}
+	}
+	return et
+}
+
+// checkComplete reports an error if an object of typ can not be defined.
+func checkComplete(typ *Type, tok *Token) {
+	for typ.kind == tyArray {
+		typ = typ.ptrTo
+	}
+	if typ.kind == tyVoid {
+		errorTok(tok, "Incomplete type \"void\"")
+		return
+	}
+	if typ.isIncomplete {
+		errorTok(tok, "Incomplete type")
+	}
+}
+
+// isTypename reports whether tok starts a declaration.
+func isTypename(tok *Token) bool {
+	if tok.kind == tkIdent {
+		v := findVar(tok.str)
+		return v != nil && v.isTypedef
+	}
+	if tok.kind != tkReserved {
+		return false
+	}
+	switch string(tok.str) {
+	case "typedef", "void", "_Bool", "char", "short", "int", "long", "float", "double", "signed", "unsigned",
+		"struct", "union", "enum":
+		return true
+	}
+	return false
+}
+
+// builtin parses a call of a builtin function for variadic arguments. It
+// returns nil if the name is not a builtin.
+func builtin(name *Token) *Node {
+	switch string(name.str) {
+	case "va_start":
+		expect("(")
+		ap := assign()
+		expect(",")
+		assign()
+		expect(")")
+		checkVaList(ap)
+		if !currentFunc.typ.isVariadic {
+			errorTok(name, "\"va_start\" used in function with fixed arguments")
+		}
+		return newNode(ndVaStart, ap, nil, name)
+	case "va_arg":
+		expect("(")
+		ap := assign()
+		expect(",")
+		tok := token
+		typ := typeName()
+		expect(")")
+		checkVaList(ap)
+		if typ.kind == tyFloat {
+			errorTok(tok, "Type \"float\" is promoted to \"double\" when passed through \"...\"")
+		} else if !isNumeric(typ) && typ.kind != tyPtr {
+			errorTok(tok, "Type of variadic argument is not supported")
+		}
+		addr := &Node{kind: ndVaArg, tok: name, lhs: ap, typ: typePtrTo(typ)}
+		return newNode(ndDeref, addr, nil, name)
+	case "va_end":
+		expect("(")
+		ap := assign()
+		expect(")")
+		checkVaList(ap)
+		return newNodeCast(ap, typeVoid)
+	case "va_copy":
+		expect("(")
+		dest := assign()
+		expect(",")
+		src := assign()
+		expect(")")
+		checkVaList(dest)
+		checkVaList(src)
+		// Copy the structure which va_list points to.
+		return newNode(ndAssign, newNode(ndDeref, dest, nil, name), newNode(ndDeref, src, nil, name), name)
+	}
+	return nil
+}
+
+func checkVaList(node *Node) {
+	typ := nodeType(node)
+	if (typ.kind != tyPtr && typ.kind != tyArray) || typ.ptrTo != typeVaListElem {
+		errorTok(node.tok, "Argument is not of type \"va_list\"")
+	}
 }
@@ -0,0 +1,1054 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Macro struct {
+	name       string
+	isFunc     bool     // Function-like macro
+	params     []string // Valid only if isFunc
+	isVariadic bool     // Valid only if isFunc
+	body       *Token   // Replacement list terminated by EOF
+
+	// Predefined macro whose replacement is computed at each expansion
+	handler func(tok *Token) *Token
+}
+
+// MacroArg is an actual argument of a function-like macro.
+type MacroArg struct {
+	name string
+	tok  *Token // Tokens terminated by EOF
+}
+
+// Hideset is a set of macro names already expanded for a token, which
+// prevents infinite recursion of macro expansion.
+type Hideset struct {
+	name string
+	next *Hideset
+}
+
+type CondInclCtx int
+
+const (
+	inThen CondInclCtx = iota
+	inElif
+	inElse
+)
+
+// CondIncl is a nesting level of #if.
+type CondIncl struct {
+	ctx      CondInclCtx
+	tok      *Token // "#if", "#ifdef" or "#ifndef" for diagnostics
+	included bool   // Some group of this #if is already included
+}
+
+// Directories searched by #include in addition to the directory of the
+// current file. includePaths are given by -I option.
+var includePaths []string
+var systemIncludePaths = []string{
+	"/usr/local/include",
+	"/usr/include/x86_64-linux-gnu",
+	"/usr/include",
+}
+
+const maxIncludeDepth = 200
+
+var macros map[string]*Macro
+var condIncls []*CondIncl
+
+// preprocess runs the preprocessor over the tokens of a source file and
+// returns the tokens to be parsed.
+func preprocess(tok *Token) *Token {
+	initMacros()
+	condIncls = nil
+	tok = preprocess2(tok)
+	for _, ci := range condIncls {
+		errorTok(ci.tok, "Unterminated conditional directive")
+	}
+	convertKeywords(tok)
+	convertNumbers(tok)
+	return tok
+}
+
+func initMacros() {
+	macros = make(map[string]*Macro)
+	macros["__FILE__"] = &Macro{name: "__FILE__", handler: fileMacro}
+	macros["__LINE__"] = &Macro{name: "__LINE__", handler: lineMacro}
+
+	// Standard macros and the target, which system headers depend on
+	defineMacro("__STDC__", "1")
+	defineMacro("__STDC_VERSION__", "201112L")
+	defineMacro("__STDC_HOSTED__", "1")
+	defineMacro("__STDC_NO_ATOMICS__", "1")
+	defineMacro("__STDC_NO_COMPLEX__", "1")
+	defineMacro("__STDC_NO_THREADS__", "1")
+	defineMacro("__STDC_NO_VLA__", "1")
+	defineMacro("__x86_64__", "1")
+	defineMacro("__x86_64", "1")
+	defineMacro("__amd64__", "1")
+	defineMacro("__amd64", "1")
+	defineMacro("__LP64__", "1")
+	defineMacro("_LP64", "1")
+	defineMacro("__linux__", "1")
+	defineMacro("__linux", "1")
+	defineMacro("__unix__", "1")
+	defineMacro("__unix", "1")
+	defineMacro("__ELF__", "1")
+	defineMacro("__CHAR_BIT__", "8")
+	defineMacro("__SIZEOF_SHORT__", "2")
+	defineMacro("__SIZEOF_INT__", "4")
+	defineMacro("__SIZEOF_LONG__", "8")
+	defineMacro("__SIZEOF_LONG_LONG__", "8")
+	defineMacro("__SIZEOF_POINTER__", "8")
+	defineMacro("__SIZEOF_FLOAT__", "4")
+	defineMacro("__SIZEOF_DOUBLE__", "8")
+	defineMacro("__SIZEOF_WCHAR_T__", "4")
+	defineMacro("__WCHAR_MAX__", "0x7fffffff")
+	defineMacro("__WCHAR_MIN__", "(-0x7fffffff - 1)")
+	defineMacro("__SIZE_TYPE__", "unsigned long")
+	defineMacro("__PTRDIFF_TYPE__", "long")
+	defineMacro("__9cc__", "1")
+}
+
+// defineMacro defines an object-like macro whose replacement list is body.
+func defineMacro(name string, body string) {
+	tok := tokenize(newFile("<built-in>", []rune(body)))
+	tok.atBol = false
+	macros[name] = &Macro{name: name, body: tok}
+}
+
+func fileMacro(tmpl *Token) *Token {
+	for tmpl.origin != nil {
+		tmpl = tmpl.origin
+	}
+	return newStrToken(tmpl.pos.file.name, tmpl)
+}
+
+func lineMacro(tmpl *Token) *Token {
+	for tmpl.origin != nil {
+		tmpl = tmpl.origin
+	}
+	return newNumToken(tmpl.pos.lineNo(), tmpl)
+}
+
+// preprocess2 expands macros and processes directives in a token list.
+func preprocess2(tok *Token) *Token {
+	head := Token{}
+	cur := &head
+
+	for tok.kind != tkEOF {
+		if rest, ok := expandMacro(tok); ok {
+			tok = rest
+			continue
+		}
+
+		if !isHash(tok) {
+			cur.next = tok
+			cur = tok
+			tok = tok.next
+			continue
+		}
+
+		start := tok
+		tok = tok.next
+
+		// Null directive
+		if tok.atBol || tok.kind == tkEOF {
+			continue
+		}
+
+		// GNU line marker (e.g. "# 1 "foo.c""), which is printed by -E
+		if tok.kind == tkNum {
+			tok = readLineMarker(start, tok)
+			continue
+		}
+
+		switch string(tok.str) {
+		case "include":
+			tok = includeFile(start, tok.next)
+		case "define":
+			tok = readMacroDefinition(tok.next)
+		case "undef":
+			name := tok.next
+			if name.kind != tkIdent || name.atBol {
+				errorTok(tok, "Macro name must be an identifier")
+			} else {
+				delete(macros, string(name.str))
+			}
+			tok = skipLine(tok.next)
+		case "if":
+			val, rest := readConstExpr(tok)
+			pushCondIncl(start, val != 0)
+			tok = rest
+			if val == 0 {
+				tok = skipCondIncl(tok)
+			}
+		case "ifdef", "ifndef":
+			name := tok.next
+			if name.kind != tkIdent || name.atBol {
+				errorTok(tok, "Macro name must be an identifier")
+			}
+			defined := macros[string(name.str)] != nil
+			if string(tok.str) == "ifndef" {
+				defined = !defined
+			}
+			pushCondIncl(start, defined)
+			tok = skipLine(tok.next)
+			if !defined {
+				tok = skipCondIncl(tok)
+			}
+		case "elif":
+			if len(condIncls) == 0 || condIncls[len(condIncls)-1].ctx == inElse {
+				errorTok(tok, "#elif without #if")
+				tok = skipLine(tok)
+				break
+			}
+			ci := condIncls[len(condIncls)-1]
+			ci.ctx = inElif
+			if ci.included {
+				tok = skipCondIncl(skipLine(tok))
+				break
+			}
+			val, rest := readConstExpr(tok)
+			tok = rest
+			if val != 0 {
+				ci.included = true
+			} else {
+				tok = skipCondIncl(tok)
+			}
+		case "else":
+			if len(condIncls) == 0 || condIncls[len(condIncls)-1].ctx == inElse {
+				errorTok(tok, "#else without #if")
+				tok = skipLine(tok)
+				break
+			}
+			ci := condIncls[len(condIncls)-1]
+			ci.ctx = inElse
+			tok = skipLine(tok.next)
+			if ci.included {
+				tok = skipCondIncl(tok)
+			}
+		case "endif":
+			if len(condIncls) == 0 {
+				errorTok(tok, "#endif without #if")
+			} else {
+				condIncls = condIncls[:len(condIncls)-1]
+			}
+			tok = skipLine(tok.next)
+		case "line":
+			tok = readLineMarker(start, tok.next)
+		case "error":
+			errorTok(tok, "#error %s", lineSpelling(tok.next))
+			tok = skipLine(tok.next)
+		case "pragma":
+			tok = skipLine(tok.next)
+		default:
+			errorTok(tok, "Invalid preprocessing directive #%s", string(tok.str))
+			tok = skipLine(tok.next)
+		}
+	}
+
+	cur.next = tok
+	return head.next
+}
+
+func isHash(tok *Token) bool {
+	return tok.atBol && tok.kind == tkReserved && string(tok.str) == "#"
+}
+
+func equal(tok *Token, str string) bool {
+	return tok.kind != tkStr && string(tok.str) == str
+}
+
+// skipLine skips the rest of a directive line. Extra tokens are ignored.
+func skipLine(tok *Token) *Token {
+	for !tok.atBol && tok.kind != tkEOF {
+		tok = tok.next
+	}
+	return tok
+}
+
+func copyToken(tok *Token) *Token {
+	t := *tok
+	t.next = nil
+	return &t
+}
+
+func newEOF(tmpl *Token) *Token {
+	t := copyToken(tmpl)
+	t.kind = tkEOF
+	t.str = []rune{}
+	return t
+}
+
+// copyLine copies the rest of a directive line into a list terminated by
+// EOF and returns it with the first token of the next line.
+func copyLine(tok *Token) (*Token, *Token) {
+	head := Token{}
+	cur := &head
+	for ; !tok.atBol && tok.kind != tkEOF; tok = tok.next {
+		cur.next = copyToken(tok)
+		cur = cur.next
+	}
+	cur.next = newEOF(tok)
+	return head.next, tok
+}
+
+// copyTokens copies a list terminated by EOF.
+func copyTokens(tok *Token) *Token {
+	head := Token{}
+	cur := &head
+	for ; tok.kind != tkEOF; tok = tok.next {
+		cur.next = copyToken(tok)
+		cur = cur.next
+	}
+	cur.next = newEOF(tok)
+	return head.next
+}
+
+// lineSpelling returns the spelling of tokens until the end of the line.
+func lineSpelling(tok *Token) string {
+	var sb strings.Builder
+	for t := tok; !t.atBol && t.kind != tkEOF; t = t.next {
+		if t != tok && t.hasSpace {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(string(t.str))
+	}
+	return sb.String()
+}
+
+func newStrToken(str string, tmpl *Token) *Token {
+	t := copyToken(tmpl)
+	t.kind = tkStr
+	t.str = []rune(quoteString(str))
+	t.contents = []byte(str)
+	return t
+}
+
+func newNumToken(val int, tmpl *Token) *Token {
+	t := copyToken(tmpl)
+	t.kind = tkNum
+	t.str = []rune(strconv.Itoa(val))
+	t.val = val
+	t.typ = typeInt
+	return t
+}
+
+// quoteString returns a string literal whose contents are str.
+func quoteString(str string) string {
+	var sb strings.Builder
+	sb.WriteString("\"")
+	for _, r := range str {
+		switch r {
+		case '\\', '"':
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case '\n':
+			sb.WriteString("\\n")
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteString("\"")
+	return sb.String()
+}
+
+func newHideset(name string) *Hideset {
+	return &Hideset{name: name}
+}
+
+func hidesetContains(hs *Hideset, name string) bool {
+	for ; hs != nil; hs = hs.next {
+		if hs.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hidesetUnion(hs1 *Hideset, hs2 *Hideset) *Hideset {
+	head := Hideset{}
+	cur := &head
+	for ; hs1 != nil; hs1 = hs1.next {
+		cur.next = newHideset(hs1.name)
+		cur = cur.next
+	}
+	cur.next = hs2
+	return head.next
+}
+
+func hidesetIntersection(hs1 *Hideset, hs2 *Hideset) *Hideset {
+	head := Hideset{}
+	cur := &head
+	for ; hs1 != nil; hs1 = hs1.next {
+		if hidesetContains(hs2, hs1.name) {
+			cur.next = newHideset(hs1.name)
+			cur = cur.next
+		}
+	}
+	return head.next
+}
+
+// addHideset copies tokens adding hs to their hidesets.
+func addHideset(tok *Token, hs *Hideset) *Token {
+	head := Token{}
+	cur := &head
+	for ; tok != nil; tok = tok.next {
+		t := copyToken(tok)
+		t.hideset = hidesetUnion(t.hideset, hs)
+		cur.next = t
+		cur = t
+	}
+	return head.next
+}
+
+func readMacroDefinition(tok *Token) *Token {
+	if tok.kind != tkIdent || tok.atBol {
+		errorTok(tok, "Macro name must be an identifier")
+		return skipLine(tok)
+	}
+	name := string(tok.str)
+	tok = tok.next
+
+	m := &Macro{name: name}
+	if !tok.atBol && !tok.hasSpace && equal(tok, "(") {
+		// Function-like macro
+		m.isFunc = true
+		tok = tok.next
+		for !equal(tok, ")") {
+			if tok.atBol || tok.kind == tkEOF {
+				errorTok(tok, "Missing ')' in macro parameter list")
+				return skipLine(tok)
+			}
+			if len(m.params) > 0 || m.isVariadic {
+				if !equal(tok, ",") {
+					errorTok(tok, "Expected ',' in macro parameter list")
+					return skipLine(tok)
+				}
+				tok = tok.next
+			}
+			if equal(tok, "...") {
+				m.isVariadic = true
+				tok = tok.next
+				continue
+			}
+			if tok.kind != tkIdent || m.isVariadic {
+				errorTok(tok, "Invalid macro parameter")
+				return skipLine(tok)
+			}
+			m.params = append(m.params, string(tok.str))
+			tok = tok.next
+		}
+		tok = tok.next
+	}
+
+	m.body, tok = copyLine(tok)
+	macros[name] = m
+	return tok
+}
+
+// expandMacro expands a macro if tok is a macro invocation and returns the
+// replacement followed by the rest of tokens.
+func expandMacro(tok *Token) (*Token, bool) {
+	if tok.kind != tkIdent || hidesetContains(tok.hideset, string(tok.str)) {
+		return nil, false
+	}
+	m := macros[string(tok.str)]
+	if m == nil {
+		return nil, false
+	}
+
+	if m.handler != nil {
+		t := m.handler(tok)
+		t.next = tok.next
+		return t, true
+	}
+
+	if !m.isFunc {
+		hs := hidesetUnion(tok.hideset, newHideset(m.name))
+		body := addHideset(subst(m, nil), hs)
+		for t := body; t != nil; t = t.next {
+			t.origin = tok
+		}
+		return appendMacroBody(body, tok, tok.next), true
+	}
+
+	// A function-like macro name not followed by "(" is not an invocation.
+	if !equal(tok.next, "(") {
+		return nil, false
+	}
+
+	args, rparen := readMacroArgs(tok, m)
+	if !equal(rparen, ")") {
+		return rparen, true
+	}
+
+	// Tokens in the replacement inherit hidesets of the macro name and ")".
+	hs := hidesetIntersection(tok.hideset, rparen.hideset)
+	hs = hidesetUnion(hs, newHideset(m.name))
+	body := addHideset(subst(m, args), hs)
+	for t := body; t != nil; t = t.next {
+		t.origin = tok
+	}
+	return appendMacroBody(body, tok, rparen.next), true
+}
+
+// appendMacroBody links the replacement of a macro invocation to rest.
+func appendMacroBody(body *Token, macroTok *Token, rest *Token) *Token {
+	if body.kind == tkEOF {
+		return rest
+	}
+	body.atBol = macroTok.atBol
+	body.hasSpace = macroTok.hasSpace
+	return joinTokens([]*Token{body, rest})
+}
+
+// readMacroArgs reads actual arguments of a function-like macro invocation
+// and returns them with the closing parenthesis.
+func readMacroArgs(macroTok *Token, m *Macro) ([]*MacroArg, *Token) {
+	tok := macroTok.next.next
+	var args []*MacroArg
+	for i, param := range m.params {
+		if i > 0 {
+			if !equal(tok, ",") {
+				errorTok(macroTok, "Too few arguments to macro \"%s\"", m.name)
+				return args, skipMacroArgs(tok)
+			}
+			tok = tok.next
+		}
+		var arg *MacroArg
+		arg, tok = readMacroArg(tok, false)
+		arg.name = param
+		args = append(args, arg)
+	}
+
+	if m.isVariadic {
+		var arg *MacroArg
+		if equal(tok, ")") {
+			arg = &MacroArg{tok: newEOF(tok)}
+		} else {
+			if len(m.params) > 0 {
+				if !equal(tok, ",") {
+					errorTok(macroTok, "Too few arguments to macro \"%s\"", m.name)
+					return args, skipMacroArgs(tok)
+				}
+				tok = tok.next
+			}
+			arg, tok = readMacroArg(tok, true)
+		}
+		arg.name = "__VA_ARGS__"
+		args = append(args, arg)
+	}
+
+	if !equal(tok, ")") {
+		if tok.kind != tkEOF {
+			errorTok(macroTok, "Too many arguments to macro \"%s\"", m.name)
+		}
+		return args, skipMacroArgs(tok)
+	}
+	return args, tok
+}
+
+// readMacroArg reads an actual argument until "," or ")" at the top level.
+// If readRest, "," does not terminate the argument.
+func readMacroArg(tok *Token, readRest bool) (*MacroArg, *Token) {
+	head := Token{}
+	cur := &head
+	level := 0
+	for {
+		if level == 0 && equal(tok, ")") {
+			break
+		}
+		if level == 0 && !readRest && equal(tok, ",") {
+			break
+		}
+		if tok.kind == tkEOF {
+			errorTok(tok, "Unterminated macro invocation")
+			break
+		}
+		if equal(tok, "(") {
+			level++
+		} else if equal(tok, ")") {
+			level--
+		}
+		cur.next = copyToken(tok)
+		cur = cur.next
+		tok = tok.next
+	}
+	cur.next = newEOF(tok)
+	return &MacroArg{tok: head.next}, tok
+}
+
+// skipMacroArgs skips the rest of a broken macro invocation.
+func skipMacroArgs(tok *Token) *Token {
+	level := 0
+	for tok.kind != tkEOF {
+		if equal(tok, "(") {
+			level++
+		} else if equal(tok, ")") {
+			if level == 0 {
+				return tok
+			}
+			level--
+		}
+		tok = tok.next
+	}
+	return tok
+}
+
+func findArg(args []*MacroArg, tok *Token) *MacroArg {
+	for _, arg := range args {
+		if tok.kind == tkIdent && string(tok.str) == arg.name {
+			return arg
+		}
+	}
+	return nil
+}
+
+// subst replaces parameters in a macro body with actual arguments and
+// evaluates "#" and "##" operators. "#" is an operator only in bodies of
+// function-like macros.
+func subst(m *Macro, args []*MacroArg) *Token {
+	head := Token{}
+	cur := &head
+
+	tok := m.body
+	for tok.kind != tkEOF {
+		// "#" followed by a parameter is replaced with the stringized
+		// argument.
+		if m.isFunc && equal(tok, "#") {
+			arg := findArg(args, tok.next)
+			if arg == nil {
+				errorTok(tok, "'#' is not followed by a macro parameter")
+				tok = tok.next
+				continue
+			}
+			cur.next = stringize(tok, arg.tok)
+			cur = cur.next
+			tok = tok.next.next
+			continue
+		}
+
+		// "##" concatenates adjacent tokens. Arguments of its operands are
+		// not macro-expanded.
+		if equal(tok, "##") {
+			if cur == &head {
+				errorTok(tok, "'##' cannot appear at start of macro expansion")
+				tok = tok.next
+				continue
+			}
+			if tok.next.kind == tkEOF {
+				errorTok(tok, "'##' cannot appear at end of macro expansion")
+				tok = tok.next
+				continue
+			}
+
+			arg := findArg(args, tok.next)
+			if arg == nil {
+				*cur = *paste(cur, tok.next)
+				tok = tok.next.next
+				continue
+			}
+			if arg.tok.kind != tkEOF {
+				*cur = *paste(cur, arg.tok)
+				for t := arg.tok.next; t.kind != tkEOF; t = t.next {
+					cur.next = copyToken(t)
+					cur = cur.next
+				}
+			}
+			tok = tok.next.next
+			continue
+		}
+
+		arg := findArg(args, tok)
+		if arg != nil && equal(tok.next, "##") {
+			rhs := tok.next.next
+			if arg.tok.kind == tkEOF {
+				// The left operand is empty, so the right one is used as is.
+				if arg2 := findArg(args, rhs); arg2 != nil {
+					for t := arg2.tok; t.kind != tkEOF; t = t.next {
+						cur.next = copyToken(t)
+						cur = cur.next
+					}
+				} else {
+					cur.next = copyToken(rhs)
+					cur = cur.next
+				}
+				tok = rhs.next
+				continue
+			}
+			for t := arg.tok; t.kind != tkEOF; t = t.next {
+				cur.next = copyToken(t)
+				cur = cur.next
+			}
+			tok = tok.next
+			continue
+		}
+
+		// Other parameters are replaced with fully macro-expanded arguments.
+		if arg != nil {
+			t := preprocess2(copyTokens(arg.tok))
+			if t.kind != tkEOF {
+				t.atBol = tok.atBol
+				t.hasSpace = tok.hasSpace
+			}
+			for ; t.kind != tkEOF; t = t.next {
+				cur.next = copyToken(t)
+				cur = cur.next
+			}
+			tok = tok.next
+			continue
+		}
+
+		cur.next = copyToken(tok)
+		cur = cur.next
+		tok = tok.next
+	}
+
+	cur.next = tok
+	return head.next
+}
+
+// stringize returns a string literal token spelling tokens of arg.
+func stringize(hash *Token, arg *Token) *Token {
+	var sb strings.Builder
+	for t := arg; t.kind != tkEOF; t = t.next {
+		if t != arg && t.hasSpace {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(string(t.str))
+	}
+	return newStrToken(sb.String(), hash)
+}
+
+// paste concatenates two tokens into a new token.
+func paste(lhs *Token, rhs *Token) *Token {
+	str := string(lhs.str) + string(rhs.str)
+	tok := tokenize(newFile(lhs.pos.file.name, []rune(str)))
+	if tok.kind == tkEOF || tok.next.kind != tkEOF {
+		errorTok(lhs, "Pasting \"%s\" and \"%s\" does not give a valid preprocessing token",
+			string(lhs.str), string(rhs.str))
+		return lhs
+	}
+	tok.pos = lhs.pos
+	tok.atBol = lhs.atBol
+	tok.hasSpace = lhs.hasSpace
+	tok.hideset = lhs.hideset
+	tok.origin = lhs.origin
+	return tok
+}
+
+// includeFile processes #include and returns the tokens of the included
+// file followed by the rest.
+func includeFile(hash *Token, tok *Token) *Token {
+	name, isQuoted, rest := readIncludeFilename(tok)
+	if name == "" {
+		errorTok(tok, "Expected \"FILENAME\" or <FILENAME>")
+		return rest
+	}
+
+	path := searchIncludePath(name, isQuoted, hash.pos.file)
+	if path == "" {
+		errorTok(tok, "%s: No such file or directory", name)
+		return rest
+	}
+	if hash.pos.file.depth >= maxIncludeDepth {
+		errorTok(tok, "#include nested too deeply")
+		return rest
+	}
+
+	file := readFile(path)
+	file.depth = hash.pos.file.depth + 1
+	included := tokenize(file)
+	if included.kind == tkEOF {
+		return rest
+	}
+	return joinTokens([]*Token{included, rest})
+}
+
+// readIncludeFilename reads "FILENAME" or <FILENAME> after #include and
+// returns the next line. The name is empty if the line is not well-formed.
+func readIncludeFilename(tok *Token) (string, bool, *Token) {
+	if tok.kind == tkStr || equal(tok, "<") {
+		name, isQuoted := includeFilename(tok)
+		return name, isQuoted, skipLine(tok)
+	}
+
+	// #include FOO where FOO is a macro
+	line, rest := copyLine(tok)
+	line = preprocess2(line)
+	name, isQuoted := includeFilename(line)
+	return name, isQuoted, rest
+}
+
+func includeFilename(tok *Token) (string, bool) {
+	if tok.atBol {
+		return "", false
+	}
+
+	// #include "foo.h"
+	if tok.kind == tkStr {
+		// Escape sequences are not interpreted in the file name.
+		return string(tok.str[1 : len(tok.str)-1]), true
+	}
+
+	// #include <foo.h>
+	if equal(tok, "<") {
+		for t := tok.next; !t.atBol && t.kind != tkEOF; t = t.next {
+			if !equal(t, ">") {
+				continue
+			}
+			if t.pos.file == tok.pos.file {
+				// Use the source text since a file name is not a sequence
+				// of C tokens.
+				return string(tok.pos.file.contents[tok.pos.offset+1 : t.pos.offset]), false
+			}
+			var sb strings.Builder
+			for u := tok.next; u != t; u = u.next {
+				if u != tok.next && u.hasSpace {
+					sb.WriteString(" ")
+				}
+				sb.WriteString(string(u.str))
+			}
+			return sb.String(), false
+		}
+	}
+	return "", false
+}
+
+func searchIncludePath(name string, isQuoted bool, from *File) string {
+	if filepath.IsAbs(name) {
+		if fileExists(name) {
+			return name
+		}
+		return ""
+	}
+
+	var dirs []string
+	if isQuoted {
+		dir := "."
+		if from.path != "<stdin>" {
+			dir = filepath.Dir(from.path)
+		}
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, includePaths...)
+	if dir := builtinIncludePath(); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, systemIncludePaths...)
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// builtinIncludePath returns the directory of headers bundled with 9cc,
+// which is "include" next to the executable.
+func builtinIncludePath() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(exe), "include")
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// readLineMarker processes "#line 10 "foo.c"" or "# 10 "foo.c"" and returns
+// the next line.
+func readLineMarker(hash *Token, tok *Token) *Token {
+	line, rest := copyLine(tok)
+	line = preprocess2(line)
+	convertNumbers(line)
+	if line.kind != tkNum || line.atBol || !isInteger(line.typ) {
+		errorTok(tok, "#line directive requires a positive integer argument")
+		return rest
+	}
+
+	file := *hash.pos.file
+	file.lineDelta = line.val - (hash.pos.line + 1)
+	if line.next.kind == tkStr {
+		file.name = string(line.next.contents)
+	}
+
+	// The directive affects the rest of the current file.
+	for t := rest; t.kind != tkEOF && t.pos.file == hash.pos.file; t = t.next {
+		t.pos.file = &file
+	}
+	if rest.kind == tkEOF && rest.pos.file == hash.pos.file {
+		rest.pos.file = &file
+	}
+	return rest
+}
+
+// readConstExpr reads and evaluates the constant expression of #if or #elif
+// and returns its value with the next line.
+func readConstExpr(directive *Token) (int, *Token) {
+	line, rest := copyLine(directive.next)
+	if line.kind == tkEOF {
+		errorTok(directive, "#%s with no expression", string(directive.str))
+		return 0, rest
+	}
+
+	// Replace "defined(FOO)" and "defined FOO" with 1 or 0.
+	head := Token{}
+	cur := &head
+	for tok := line; tok.kind != tkEOF; tok = tok.next {
+		if tok.kind != tkIdent || string(tok.str) != "defined" {
+			cur.next = tok
+			cur = tok
+			continue
+		}
+
+		start := tok
+		hasParen := equal(tok.next, "(")
+		if hasParen {
+			tok = tok.next
+		}
+		tok = tok.next
+		if tok.kind != tkIdent {
+			errorTok(start, "Macro name must be an identifier")
+			return 0, rest
+		}
+		val := 0
+		if macros[string(tok.str)] != nil {
+			val = 1
+		}
+		if hasParen {
+			if !equal(tok.next, ")") {
+				errorTok(start, "Missing ')' after \"defined\"")
+				return 0, rest
+			}
+			tok = tok.next
+		}
+		cur.next = newNumToken(val, start)
+		cur = cur.next
+	}
+	cur.next = newEOF(directive)
+	line = preprocess2(head.next)
+
+	// Identifiers remaining after macro expansion are replaced with 0.
+	for tok := line; tok.kind != tkEOF; tok = tok.next {
+		if tok.kind == tkIdent {
+			tok.kind = tkNum
+			tok.val = 0
+			tok.typ = typeInt
+		}
+	}
+	line.atBol = false
+	convertNumbers(line)
+
+	return evalPPExpr(line), rest
+}
+
+// evalPPExpr evaluates tokens of #if with the parser.
+func evalPPExpr(line *Token) (val int) {
+	saved := token
+	evalIntmax = true
+	defer func() {
+		token = saved
+		evalIntmax = false
+		if r := recover(); r != nil {
+			if _, ok := r.(syntaxError); !ok {
+				panic(r)
+			}
+			val = 0
+		}
+	}()
+
+	// Operators with side effects are not constant expressions, and they
+	// can not be parsed outside of functions.
+	for tok := line; tok.kind != tkEOF; tok = tok.next {
+		if isSideEffectOp(tok) {
+			errorTok(tok, "Operator \"%s\" is not allowed in #if", string(tok.str))
+			return 0
+		}
+	}
+
+	token = line
+	node := conditional()
+	addTypes(node)
+	if !atEOF() {
+		syntaxErrorTok(token, "Extra tokens in constant expression")
+	}
+	return eval(node)
+}
+
+func isSideEffectOp(tok *Token) bool {
+	if tok.kind != tkReserved {
+		return false
+	}
+	if equal(tok, "=") || equal(tok, "++") || equal(tok, "--") {
+		return true
+	}
+	for _, c := range compoundAssignOps {
+		if equal(tok, c.op) {
+			return true
+		}
+	}
+	return false
+}
+
+func pushCondIncl(tok *Token, included bool) {
+	condIncls = append(condIncls, &CondIncl{
+		ctx:      inThen,
+		tok:      tok,
+		included: included,
+	})
+}
+
+// skipCondIncl skips a group of #if until #elif, #else or #endif of the
+// same level.
+func skipCondIncl(tok *Token) *Token {
+	for tok.kind != tkEOF {
+		if isHash(tok) && isDirective(tok.next, "if", "ifdef", "ifndef") {
+			tok = skipCondIncl2(tok.next.next)
+			continue
+		}
+		if isHash(tok) && isDirective(tok.next, "elif", "else", "endif") {
+			return tok
+		}
+		tok = tok.next
+	}
+	return tok
+}
+
+// skipCondIncl2 skips a nested #if including its #endif.
+func skipCondIncl2(tok *Token) *Token {
+	for tok.kind != tkEOF {
+		if isHash(tok) && isDirective(tok.next, "if", "ifdef", "ifndef") {
+			tok = skipCondIncl2(tok.next.next)
+			continue
+		}
+		if isHash(tok) && isDirective(tok.next, "endif") {
+			return tok.next.next
+		}
+		tok = tok.next
+	}
+	return tok
+}
+
+func isDirective(tok *Token, names ...string) bool {
+	if tok.atBol {
+		return false
+	}
+	for _, name := range names {
+		if equal(tok, name) {
+			return true
+		}
+	}
+	return false
+}
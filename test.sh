@@ -4,7 +4,7 @@ try() {
   expected="$1"
   input="$2"
 
-  ./9cc "$input" > tmp.s
+  echo "$input" | ./9cc - > tmp.s
   gcc -static -o tmp tmp.s test/*.o
   ./tmp
   actual="$?"
@@ -66,6 +66,191 @@ try   8 'int main(){ 1+func3(1, 2, 3); }'
 try  12 'int main(){ 1+func4(1, 2, 3, 4); }'
 try  17 'int main(){ 1+func5(1, 2, 3, 4, 5); }'
 try  23 'int main(){ 1+func6(1, 2, 3, 4, 5, 6); }'
+try  30 'int main(){ 1+func7(1, 2, 3, 4, 5, 6, 7); }'
+try  38 'int main(){ 1+func8(1, 2, 3, 4, 5, 6, 7, 8); }'
+try  47 'int main(){ 1+func9(1, 2, 3, 4, 5, 6, 7, 8, 9); }'
+try  57 'int main(){ 1+func10(1, 2, 3, 4, 5, 6, 7, 8, 9, 10); }'
+try  56 'int main(){ int x; x = 1; x + func10(1, 2, 3, 4, 5, 6, 7, 8, 9, func1(8)); }'
+try  55 'int sum(int a, int b, int c, int d, int e, int f, int g, int h, int i, int j){ return a + b + c + d + e + f + g + h + i + j; } int main(){ sum(1, 2, 3, 4, 5, 6, 7, 8, 9, 10); }'
+try   9 'int last(char a, char b, char c, char d, char e, char f, char g, short h, long i){ return i - h; } int main(){ last(1, 2, 3, 4, 5, 6, 7, 8, 17); }'
+try  45 'double sum(double a, double b, double c, double d, double e, double f, double g, double h, double i, float j){ return a + b + c + d + e + f + g + h + i + j; } int main(){ return sum(0.5, 1.5, 2.5, 3.5, 4.5, 5.5, 6.5, 7.5, 8.5, 4.5f); }'
+try   1 'int sprintf(char *buf, char *fmt, ...); int strcmp(char *a, char *b); int main(){ char buf[32]; sprintf(buf, "%d %s %.2f", 7, "x", 1.5); return strcmp(buf, "7 x 1.50") == 0; }'
+try  55 'int sum(int n, ...){ va_list ap; va_start(ap, n); int s; s = 0; int i; for (i = 0; i < n; i = i + 1) s = s + va_arg(ap, int); va_end(ap); return s; } int main(){ sum(10, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10); }'
+try  45 'double fsum(int n, ...){ va_list ap; va_start(ap, n); double s; s = 0; int i; for (i = 0; i < n; i = i + 1) s = s + va_arg(ap, double); va_end(ap); return s; } int main(){ return fsum(10, 0.5, 1.5, 2.5, 3.5, 4.5, 5.5, 6.5, 7.5, 8.5, 4.5f); }'
+try  15 'long mixed(char *fmt, ...){ va_list ap; va_start(ap, fmt); long s; s = 0; while (*fmt) { if (*fmt == 100) s = s + va_arg(ap, int); if (*fmt == 102) s = s + va_arg(ap, double); if (*fmt == 108) s = s + va_arg(ap, long); fmt = fmt + 1; } va_end(ap); return s; } int main(){ return mixed("dfldf", 1, 2.5, 3L, 4, 5.5); }'
+try   1 'int vsprintf(char *buf, char *fmt, va_list ap); int strcmp(char *a, char *b); char buf[32]; void fmt(char *f, ...){ va_list ap; va_start(ap, f); vsprintf(buf, f, ap); va_end(ap); } int main(){ fmt("%d-%d", 3, 4); return strcmp(buf, "3-4") == 0; }'
+try  12 'int sum2(int n, ...){ va_list ap; va_list aq; va_start(ap, n); va_copy(aq, ap); int s; s = va_arg(ap, int) + va_arg(ap, int); s = s + va_arg(aq, int) * 2; va_end(ap); va_end(aq); return s; } int main(){ sum2(2, 3, 3); }'
+try  24 'int main(){ va_list ap; sizeof(ap); }'
+try  39 'int f(double a, double b, double c, double d, double e, double f, double g, double h, double i, ...){ va_list ap; va_start(ap, i); int x; x = va_arg(ap, int); va_end(ap); return x + i; } int main(){ return f(1, 2, 3, 4, 5, 6, 7, 8, 9, 30); }'
+try   1 'int sprintf(char *buf, char *fmt, ...); int strcmp(char *a, char *b); int main(){ char c; char buf[16]; c = 1; sprintf(buf, "%.1f", 1.5); return strcmp(buf, "1.5") == 0; }'
+try   1 'int main(){ 1 && 2; }'
+try   0 'int main(){ 1 && 0; }'
+try   0 'int main(){ 0 && 1; }'
+try   1 'int main(){ 0 || 2; }'
+try   0 'int main(){ 0 || 0; }'
+try   1 'int main(){ 1 || 0 && 0; }'
+try   1 'int main(){ 1 == 1 && 2 < 3; }'
+try   1 'int main(){ !0; }'
+try   0 'int main(){ !3; }'
+try   1 'int main(){ !!3; }'
+try   0 'int main(){ int x; x = 0; 0 && (x = 1); x; }'
+try   0 'int main(){ int x; x = 0; 1 || (x = 1); x; }'
+try   1 'int main(){ int x; x = 0; 1 && (x = 1); x; }'
+try   1 'int main(){ int x; x = 0; x != 0 && 10 / x > 1 || 1; }'
+try   1 'int main(){ double d; d = -0.0; !d; }'
+try   0 'int main(){ double d; d = 0.5; d && 0.0; }'
+try   1 'int main(){ int *p; p = 0; !p; }'
+try   3 'int main(){ int i; int j; i = 0; j = 0; while (i < 10 && j < 3) { i = i + 1; j = j + 1; } j; }'
+try   5 'int main(){ int a[!0 + 4]; sizeof(a) / 4; }'
+try   2 'int main(){ 17 % 5; }'
+try 254 'int main(){ -17 % 5; }'
+try   3 'int main(){ unsigned x; x = 4294967295; x % 7; }'
+try   3 'int main(){ 7 & 3; }'
+try   7 'int main(){ 5 | 2; }'
+try   6 'int main(){ 5 ^ 3; }'
+try   1 'int main(){ ~0 == -1; }'
+try 250 'int main(){ ~5; }'
+try   1 'int main(){ unsigned char c; c = 0; ~c == -1; }'
+try  16 'int main(){ 1 << 4; }'
+try   4 'int main(){ 35 >> 3; }'
+try   1 'int main(){ -16 >> 2 == -4; }'
+try   1 'int main(){ unsigned x; x = 0; x = x - 16; x >> 28 == 15; }'
+try   1 'int main(){ long x; x = 1; (x << 40) >> 40 == 1; }'
+try   0 'int main(){ 1 << 31 >> 31 == 1; }'
+try   1 'int main(){ 1 << 2 + 1 == 8; }'
+try   0 'int main(){ 6 & 3 == 3; }'
+try   3 'int main(){ 1 | 2 ^ 3 & 1; }'
+try   1 'int main(){ 2 | 1 && 0 | 1; }'
+try   6 'int main(){ char a[3 << 1 | 2 & 0]; sizeof(a); }'
+try   4 'enum { A = 1 << 2, B = ~0 & 0xff, C = 7 % 4 }; int main(){ return A + B - 255 + C - 3; }'
+try   1 'enum { A = ~0u >> 31 }; int main(){ return A; }'
+try   1 'enum { A = (unsigned char)255 + 1 == 256, B = 0xffffffffu + 1 == 0 }; int main(){ return A && B; }'
+try   3 '#if ~0u >> 30 == 0x3ffffffff
+int main(){ return 3; }
+#endif'
+try   4 'int a[(unsigned long)-1 > 0]; int main(){ return sizeof(a); }'
+try   1 'enum { A = -1u / 2 == 0x7fffffff, B = -1u % 10 == 5, C = (unsigned char)1 > -1 }; int main(){ return A && B && C; }'
+try   1 '#if 0xffffffffffffffff > 0 && 0xffffffffffffffff / 2 > 0 && 0u - 1 > 0xffffffff
+int main(){ return 1; }
+#else
+int main(){ return 0; }
+#endif'
+try   1 '#if 2147483647 + 1 > 0 && 65535 * 65537 > 0
+int main(){ return 1; }
+#else
+int main(){ return 0; }
+#endif'
+try   2 'int main(){ 1 ? 2 : 3; }'
+try   3 'int main(){ 0 ? 2 : 3; }'
+try   5 'int main(){ 0 ? 1 : 0 ? 4 : 5; }'
+try   4 'int main(){ 1 ? 0 ? 3 : 4 : 5; }'
+try   1 'int main(){ int x; x = 0; 1 ? 1 : (x = 1); x == 0; }'
+try   2 'int main(){ int x; x = 0 ? 1 : 2; x; }'
+try   1 'int main(){ -1 < 0 ? 1 : 2u; }'
+try   8 'int main(){ sizeof(1 ? 1 : 2L); }'
+try   5 'int main(){ return 0.0 ? 1 : 2.5 * 2; }'
+try   1 'int main(){ double d; d = -0.0; d ? 2 : 1; }'
+try   8 'int main(){ int *p; sizeof(1 ? p : 0); }'
+try   8 'int main(){ int *p; sizeof(1 ? 0 : p); }'
+try   3 'int main(){ int a[2]; int *p; a[0] = 3; a[1] = 4; p = 0; *(p ? p : a); }'
+try   9 'int main(){ int x; int *p; void *v; p = &x; v = p; x = 9; p = 0; p = 1 ? v : p; *p; }'
+try   2 'struct S { int a; int b; }; int main(){ struct S x; struct S y; struct S z; x.a = 1; y.a = 2; z = 0 ? x : y; z.a; }'
+try   3 'void f(){} void g(){} int main(){ 1 ? f() : g(); 3; }'
+try   7 'int main(){ char a[1 ? 7 : 3]; sizeof(a); }'
+try   3 'int main(){ 1, 2, 3; }'
+try   5 'int main(){ int i; int j; for (i = 0, j = 10; i < j; i = i + 1, j = j - 1) {} i; }'
+try   4 'int main(){ int x; x = (1, 4); x; }'
+try   3 'int add(int a, int b){ return a + b; } int main(){ return add((1, 2), 1); }'
+try   8 'int main(){ int x; sizeof(x, 1L); }'
+try   6 'void f(){} int main(){ int x; x = (f(), 6); x; }'
+try   3 'int main(){ int i; i = 2; ++i; }'
+try   1 'int main(){ int i; i = 2; --i; }'
+try   2 'int main(){ int i; i = 2; i++; }'
+try   3 'int main(){ int i; i = 2; i++; i; }'
+try   2 'int main(){ int i; i = 2; i--; }'
+try   1 'int main(){ int i; i = 2; i--; i; }'
+try 255 'int main(){ unsigned char c; c = 255; c++; }'
+try   0 'int main(){ unsigned char c; c = 255; c++; c; }'
+try   3 'int main(){ int a[3]; int *p; a[0] = 1; a[1] = 2; a[2] = 3; p = a; p++; ++p; *p; }'
+try   1 'int main(){ int a[3]; int *p; a[0] = 1; a[1] = 2; a[2] = 3; p = a; *p++; }'
+try   2 'int main(){ int a[3]; int *p; a[0] = 1; a[1] = 2; a[2] = 3; p = a + 2; p--; *p--; }'
+try   4 'int main(){ int a[2]; int *p; a[0] = 1; a[1] = 3; p = a; (*p++)++; *p + a[0] - 1; }'
+try   3 'int main(){ double d; d = 1.5; d++; return d + 0.5; }'
+try   1 'int main(){ _Bool b; b = 1; b++; }'
+try   1 'int main(){ _Bool b; b = 1; b++; b; }'
+try   0 'int main(){ _Bool b; b = 0; b--; }'
+try   1 'int main(){ _Bool b; b = 0; b--; b; }'
+try   1 'int main(){ double d; d = 0.1; d++ == 0.1; }'
+try   1 'int main(){ double d; d = 0.1; d--; d == 0.1 - 1; }'
+try   1 'int main(){ float a[2]; float *p; a[1] = 0.1f; p = a; p[1]++ == 0.1f && a[1] == 0.1f + 1; }'
+try   7 'int main(){ int i; i = 5; i += 2; }'
+try   3 'int main(){ int i; i = 5; i -= 2; i; }'
+try  15 'int main(){ int i; i = 5; i *= 3; i; }'
+try   2 'int main(){ int i; i = 5; i /= 2; i; }'
+try   1 'int main(){ int i; i = 5; i %= 2; i; }'
+try   4 'int main(){ int i; i = 6; i &= 12; i; }'
+try  14 'int main(){ int i; i = 6; i |= 12; i; }'
+try  10 'int main(){ int i; i = 6; i ^= 12; i; }'
+try  24 'int main(){ int i; i = 6; i <<= 2; i; }'
+try   1 'int main(){ int i; i = 6; i >>= 2; i; }'
+try   6 'int main(){ int i; i = 5; i += 1.5; i; }'
+try   8 'int main(){ int a[3]; int *p; a[2] = 8; p = a; p += 2; *p; }'
+try   4 'int main(){ long a[3]; long *p; a[1] = 4; p = a + 3; p -= 2; *p; }'
+try   1 'int main(){ int a[3]; int i; a[0] = 0; a[1] = 0; i = 0; a[i++] += 1; a[0] == 1 && a[1] == 0 && i == 1; }'
+try   6 'int g; int *f(){ g = g + 1; return &g; } int main(){ g = 0; *f() += 5; g; }'
+try   2 'int g; int *f(){ g = g + 1; return &g; } int main(){ g = 0; (*f())++; g; }'
+try   3 'struct S { int a; char b; }; int main(){ struct S s; struct S *p; p = &s; s.b = 1; p->b += 2; s.b; }'
+try  10 'int main(){ int i; int s; s = 0; for (i = 0; i < 5; i++) s += i; s; }'
+try   5 'int main(){ int i; int j; for (i = 0, j = 10; i < j; i++, j--) {} i; }'
+try   2 'int main(){ int x; x = 1; x - -1; }'
+try  44 'int main(){ (char)300; }'
+try   1 'int main(){ (char)255 == -1; }'
+try   1 'int main(){ (unsigned char)-1 == 255; }'
+try   1 'int main(){ (short)65537 == 1; }'
+try   1 'int main(){ (unsigned short)-1 == 65535; }'
+try   1 'int main(){ (int)4294967297 == 1; }'
+try   1 'int main(){ (unsigned)-1 == 4294967295; }'
+try   1 'int main(){ (long)-1 == -1; }'
+try   1 'int main(){ (long)(unsigned)-1 == 4294967295; }'
+try   1 'int main(){ (unsigned long)(char)-1 == (unsigned long)-1; }'
+try   1 'int main(){ (_Bool)256; }'
+try   0 'int main(){ (_Bool)0.0; }'
+try   3 'int main(){ (int)3.9; }'
+try 253 'int main(){ (int)-3.9; }'
+try   1 'int main(){ (double)1 / 2 == 0.5; }'
+try   0 'int main(){ (int)(1 / 2.0); }'
+try   4 'int main(){ sizeof((char)1 + (char)1); }'
+try   1 'int main(){ sizeof((char)1); }'
+try   8 'int main(){ sizeof((long)1); }'
+try   2 'int main(){ -(int)-2; }'
+try   1 'int main(){ !(int)0; }'
+try   3 'int main(){ int x; x = 3; (void)x; x; }'
+try   8 'int main(){ long x; x = 8; *(int *)&x; }'
+try   2 'int main(){ int x; x = 0x0102; *(char *)&x; }'
+try   5 'int main(){ int x; int *p; x = 5; p = (int *)(long)&x; *p; }'
+try   4 'typedef int *IntPtr; int main(){ long a[2]; a[1] = 4; return *(IntPtr)(a + 1); }'
+try  44 'int main(){ char c; (c = 300); }'
+try   1 'int main(){ char c; (c = 300) == 44; }'
+try  44 'char f(){ return 300; } int main(){ return f(); }'
+try   1 'int f(unsigned char c){ return c == 255; } int main(){ return f(-1); }'
+try   1 'int main(){ _Bool b; int x; int *p; p = &x; b = p; b; }'
+try   1 'int main(){ unsigned u; u = 1; -1 < u == 0; }'
+try   1 'int main(){ long l; int i; i = -1; l = i; l == -1; }'
+try   1 'int main(){ unsigned long l; unsigned i; i = -1; l = i; l == 4294967295; }'
+try  44 'int main(){ char a[(char)300]; sizeof(a); }'
+try   1 'enum { A = (unsigned char)-1 }; int main(){ return A == 255; }'
+try   0 'int main(){ int i; double d; i = 1; d = 0.5; d = d + (i - 1); (int)d; }'
+try   2 '#if 1 ? 0 : 1
+int main(){ return 1; }
+#else
+int main(){ return 2; }
+#endif'
+try   2 '#if !defined(FOO) && (1 || 0)
+int main(){ return 2; }
+#else
+int main(){ return 3; }
+#endif'
+try  21 'int mix(int a, double b, int c, double d, int e, int f, int g, int h, int i, double j){ return a + b + c + d + e + f + g + h + i + j; } int main(){ return mix(1, 2.0, 1, 2.0, 1, 1, 1, 5, 6, 1.0); }'
 try   4 'int main(){ 1+add(1, 2); } int add(int a, int b){ a + b; }'
 try   7 'int mul(int a, int b){ a * b; } int main(){ 1+mul(2, 3); }'
 try   0 'int fib(int n){ if(n==0) return 0; if(n==1) return 1; fib(n-2)+fib(n-1); } int main(){ fib(0); }'
@@ -101,5 +286,595 @@ try   5 'int a; int main(){ a=5; a; }'
 try   4 'int a[3]; int main(){ a[0]=1; a[2]=3; a[0]+a[1]+a[2]; }'
 try   3 'char a[3]; int main(){ a[0]=-1; a[2]=2; int b; b=4; a[0]+b; }'
 try   7 'int add(char a, char b){ a + b; } int main(){ add(-3, 10); }'
+try   4 'int main(){ sizeof("abc"); }'
+try  98 'int main(){ "abc"[1]; }'
+try   0 'int main(){ "abc"[3]; }'
+try   5 'int main(){ sizeof("ab" "cd"); }'
+try  99 'int main(){ ("ab" "cd")[2]; }'
+try  10 'int main(){ "\n"[0]; }'
+try  34 'int main(){ "\""[0]; }'
+try  65 'int main(){ "\x41"[0]; }'
+try  65 'int main(){ "\101"[0]; }'
+try   0 'int main(){ "a\0b"[1]; }'
+try   4 'int main(){ sizeof("a\0b"); }'
+try   3 'int main(){ sizeof("\u00e9"); }'
+try   1 'int main(){ char *p; char *q; p="abc"; q="abc"; p==q; }'
+try   5 'int main(){ strlen("hello"); }'
+try   6 'int main(){ printf("hello\n"); }'
+try  97 "int main(){ 'a'; }"
+try  10 "int main(){ '\\n'; }"
+try  39 "int main(){ '\\''; }"
+try  92 "int main(){ '\\\\'; }"
+try   0 "int main(){ '\\0'; }"
+try 127 "int main(){ '\\177'; }"
+try  65 "int main(){ '\\x41'; }"
+try   1 "int main(){ '\\377' < 0; }"
+try   4 "int main(){ sizeof('a'); }"
+try   3 "int main(){ int a[4]; a['b'-'a'] = 3; a[1]; }"
+try   3 'int main(){ // return 1;
+  return 3; }'
+try   3 'int main(){ /* return 1; */ return 3; }'
+try   2 'int main(){ /* multi
+  line // comment
+*/ return 4 / /**/ 2; }'
+try   3 'int main(){ sizeof("//"); }'
+try  31 'int main(){ 0x1F; }'
+try  31 'int main(){ 0X1f; }'
+try  15 'int main(){ 017; }'
+try   5 'int main(){ 0b101; }'
+try   0 'int main(){ 0; }'
+try   4 'int main(){ sizeof(1); }'
+try   4 'int main(){ sizeof(1u); }'
+try   8 'int main(){ sizeof(1L); }'
+try   8 'int main(){ sizeof(1ll); }'
+try   8 'int main(){ sizeof(1UL); }'
+try   8 'int main(){ sizeof(1LLU); }'
+try   4 'int main(){ sizeof(2147483647); }'
+try   8 'int main(){ sizeof(2147483648); }'
+try   4 'int main(){ sizeof(0xffffffff); }'
+try   8 'int main(){ sizeof(0x100000000); }'
+try   8 'int main(){ sizeof(1L + 1); }'
+try   1 'int main(){ 0x100000001 - 0x100000000; }'
+try   1 'int main(){ 0xffffffffffffffff + 2; }'
+try   5 'int main(){ int a[2][3]; a[1][2] = 5; a[1][2]; }'
+try  24 'int main(){ int a[2][3]; sizeof(a); }'
+try  12 'int main(){ int a[2][3]; sizeof(a[0]); }'
+try   8 'int main(){ struct { int a; int b; } x; sizeof(x); }'
+try   8 'int main(){ struct { char a; int b; } x; sizeof(x); }'
+try  12 'int main(){ struct { char a; int b; char c; } x; sizeof(x); }'
+try  16 'int main(){ struct { char a; int *b; } x; sizeof(x); }'
+try   3 'int main(){ struct { char a[3]; } x; sizeof(x); }'
+try   3 'int main(){ struct { int a; int b; } x; x.a = 1; x.b = 2; x.a + x.b; }'
+try   7 'int main(){ struct { int a; struct { char b; int c; } y; } x; x.y.c = 7; x.y.c; }'
+try   6 'int main(){ struct { int a[3]; } x; x.a[0] = 1; x.a[2] = 5; x.a[0] + x.a[2]; }'
+try   5 'int main(){ struct { int a; } x[3]; x[2].a = 5; x[2].a; }'
+try   8 'int main(){ struct S { int a; int b; }; sizeof(struct S); }'
+try   4 'int main(){ struct S { int a; int b; } x; struct S *p; p = &x; p->b = 4; x.b; }'
+try   9 'int main(){ struct S { int a; int b; } x; struct S y; x.a = 4; x.b = 5; y = x; y.a + y.b; }'
+try   6 'struct P { int x; int y; }; struct P p; int main(){ p.x = 2; p.y = 3; p.x * p.y; }'
+try  10 'int main(){ struct node { int val; struct node *next; } a; struct node b; a.val = 3; b.val = 7; a.next = &b; b.next = 0; a.val + a.next->val; }'
+try   8 'int main(){ struct T; struct T *p; struct T { int a; int b; }; sizeof(struct T); }'
+try   8 'int main(){ union { int a; char b[6]; } x; sizeof(x); }'
+try   4 'int main(){ union { int a; char b[3]; } x; sizeof(x); }'
+try   5 'int main(){ union { int a; char b[4]; } x; x.a = 515; x.b[0] + x.b[1]; }'
+try   5 'int main(){ union U { int a; struct { char b; int c; } s; } x; x.s.c = 5; x.s.c; }'
+try   8 'int main(){ struct { int kind; union { int i; char c; }; } x; sizeof(x); }'
+try   7 'int main(){ struct { int kind; union { int i; char c; }; } x; x.kind = 1; x.i = 6; x.kind + x.i; }'
+try  12 'int main(){ struct { char a; struct { int b; int c; }; } x; sizeof(x); }'
+try  10 'int main(){ struct { char a; struct { int b; int c; }; } x; x.b = 1; x.c = 9; x.b + x.c; }'
+try   2 'int main(){ union { int a; struct { char b; char c; }; } x; x.a = 513; x.c; }'
+try  16 'int main(){ union { struct { int a; int b; }; struct { char c; int *d; }; } x; sizeof(x); }'
+try   0 'int main(){ enum { A, B, C }; A; }'
+try   2 'int main(){ enum { A, B, C }; C; }'
+try   6 'int main(){ enum { A = 5, B, C = 1 }; B + C - 1; }'
+try   7 'int main(){ enum { A = 3, B = A + 4 }; B; }'
+try   4 'int main(){ enum E { A, B }; enum E x; sizeof(x); }'
+try   3 'int main(){ enum E { A, B, }; enum E x; x = 3; x; }'
+try  12 'int main(){ enum { N = 3 }; int a[N]; sizeof(a); }'
+try  24 'int main(){ int a[2 * 3]; sizeof(a); }'
+try   5 'enum { N = 5 }; int a[N]; int main(){ sizeof(a) / sizeof(a[0]); }'
+try   2 'enum Color { RED, GREEN, BLUE }; int main(){ enum Color c; c = BLUE; c; }'
+try   8 'int main(){ enum { A = 1 }; int b; b = 7; A + b; }'
+try   7 'int A; int main(){ enum { A = 7 }; A; }'
+try   4 'int main(){ typedef int T; T x; x = 4; x; }'
+try   8 'int main(){ typedef int *P; P p; sizeof(p); }'
+try   3 'typedef struct { int a; int b; } Pair; int main(){ Pair p; p.a = 1; p.b = 2; p.a + p.b; }'
+try   5 'typedef struct node Node; struct node { int val; Node *next; }; int main(){ Node a; Node b; Node *n; a.next = &b; b.val = 5; n = &a; n->next->val; }'
+try   8 'int main(){ typedef int T; sizeof(T *); }'
+try  12 'int main(){ typedef int T[3]; sizeof(T); }'
+try  24 'int main(){ int *a[3]; sizeof(a); }'
+try   8 'int main(){ int (*a)[3]; sizeof(a); }'
+try  12 'int main(){ int (*a)[3]; sizeof(*a); }'
+try  24 'int main(){ sizeof(int *[3]); }'
+try   8 'int main(){ sizeof(int (*)[3]); }'
+try   3 'int main(){ int a, *b, c[2]; b = &a; a = 1; c[1] = 2; *b + c[1]; }'
+try   4 'typedef int T; int main(){ int T; T = 4; T; }'
+try   6 'int main(){ typedef int A, *B; A a; B b; b = &a; *b = 6; a; }'
+try   4 'typedef int T; T f(T x){ return x; } int main(){ f(4); }'
+try   7 'int sum(int a[3], int n){ return a[0] + a[n - 1]; } int main(){ int x[3]; x[0] = 3; x[2] = 4; sum(x, 3); }'
+try   2 'int main(){ struct { int a, b; } x; x.b = 2; x.b; }'
+try   1 'int main(){ _Bool x; sizeof(x); }'
+try   2 'int main(){ short x; sizeof(x); }'
+try   2 'int main(){ unsigned short int x; sizeof(x); }'
+try   8 'int main(){ long x; sizeof(x); }'
+try   8 'int main(){ long long int x; sizeof(x); }'
+try   8 'int main(){ unsigned long long x; sizeof(x); }'
+try   4 'int main(){ unsigned x; sizeof(x); }'
+try   4 'int main(){ signed x; sizeof(x); }'
+try   1 'int main(){ signed char x; sizeof(x); }'
+try   8 'int main(){ short x; long y; sizeof(x + y); }'
+try   4 'int main(){ char x; short y; sizeof(x + y); }'
+try   4 'int main(){ struct { char a; short b; } x; sizeof(x); }'
+try   1 'int main(){ _Bool x; x = 2; x; }'
+try   0 'int main(){ _Bool x; x = 0; x; }'
+try 255 'int main(){ unsigned char x; x = 255; x; }'
+try   1 'int main(){ char x; x = 255; x == -1; }'
+try   1 'int main(){ short x; x = 65535; x == -1; }'
+try   1 'int main(){ unsigned short x; x = 65535; x == 65535; }'
+try   1 'int main(){ int x; x = 4294967295; x == -1; }'
+try   1 'int main(){ unsigned x; x = 4294967295; x == -1; }'
+try   1 'int main(){ unsigned x; x = 0; x - 1 > 0; }'
+try   0 'int main(){ int x; x = 0; x - 1 > 0; }'
+try   1 'int main(){ int x; unsigned y; x = -1; y = 1; x > y; }'
+try   0 'int main(){ int x; long y; x = -1; y = 1; x > y; }'
+try   1 'int main(){ long x; x = 4294967296; x > 0; }'
+try   3 'int main(){ long x; x = 4294967299; x - 4294967296; }'
+try 127 'int main(){ unsigned x; x = 4294967294; x / 2 / 16777216; }'
+try 255 'int main(){ int x; x = -2; x / 2 + 256; }'
+try   1 'int main(){ unsigned long x; x = 0; x - 1 > 0; }'
+try   1 'int main(){ int x; x = 2147483647; x + 1 < 0; }'
+try   3 'int add(short a, short b){ return a + b; } int main(){ add(1, 2); }'
+try   1 'int main(){ unsigned char a; char b; a = 200; b = 200; a > b; }'
+try   3 'int x; void set(int v){ x = v; } int main(){ set(3); return x; }'
+try   5 'int x; void set(int v){ if (v < 0) return; x = v; } int main(){ x = 5; set(-1); return x; }'
+try   2 'int f(void){ return 2; } int main(){ return f(); }'
+try   8 'int main(){ void *p; sizeof(p); }'
+try   4 'int main(){ int x; void *p; int *q; x = 4; p = &x; q = p; *q; }'
+try   8 'int main(){ int x[2]; void *p; p = x; sizeof(p); }'
+try   7 'int main(){ char x[4]; void *p; char *q; p = x; q = p + 1; x[1] = 7; *q; }'
+try   6 'int fact(int n){ if (n <= 1) return 1; return n * fact(n - 1); } int main(){ fact(3); }'
+try 120 'long fact(long n){ if (n <= 1) return 1; return n * fact(n - 1); } int main(){ fact(5); }'
+try   4 'int main(){ float x; sizeof(x); }'
+try   8 'int main(){ double x; sizeof(x); }'
+try   8 'int main(){ sizeof(1.5); }'
+try   4 'int main(){ sizeof(1.5f); }'
+try   8 'int main(){ sizeof(1.5f + 1.0); }'
+try   8 'int main(){ sizeof(1 + 1.0); }'
+try   4 'int main(){ sizeof(1 + 1.0f); }'
+try   3 'int main(){ double x; x = 3.7; int y; y = x; y; }'
+try   6 'int main(){ return 1.5 * 4; }'
+try   2 'int main(){ 5 / 2.0 + 0.1 > 2.5; return 2.5 + 0.5 - 1; }'
+try  15 'int main(){ return .5e1 + 1e1; }'
+try   7 'int main(){ return 0.75e+1 + 0.1f - 0.1f; }'
+try   1 'int main(){ 0.1 + 0.2 != 0.3; }'
+try   1 'int main(){ 1.5f == 1.5; }'
+try   1 'int main(){ 1.0 < 2; }'
+try   0 'int main(){ 2.0 <= 1; }'
+try   1 'int main(){ 3 > 2.5f; }'
+try   0 'int main(){ double x; x = -0.0; if (x) return 1; return 0; }'
+try   1 'int main(){ double d; d = 0.0; 1 / -d < 0; }'
+try   1 'int main(){ float f; f = 0.0f; 1 / -f < 0; }'
+try   1 'int main(){ double d; d = 1.5; -d == -1.5; }'
+try   1 'int main(){ float f; f = 2.5f; -f == -2.5; }'
+try   1 'int main(){ char c; c = -128; -c == 128; }'
+try   1 'int main(){ unsigned u; u = 1; -u == 4294967295; }'
+try   1 'int main(){ long l; l = 1; -l == -1; }'
+try   3 'int main(){ - -3; }'
+try   1 'int main(){ double x; x = 0.5; if (x) return 1; return 0; }'
+try   2 'int main(){ _Bool b; b = 0.5; b + 1; }'
+try 250 'int main(){ float x; x = 4294967295; unsigned long y; y = 18446744073709551615; double z; z = y; (z == 18446744073709551616.0) * 250; }'
+try   5 'double half(double x){ return x / 2; } int main(){ return half(10); }'
+try   4 'float add(float a, float b){ return a + b; } int main(){ return add(1.5f, 2.5f); }'
+try   7 'double mix(int a, double b, int c, float d){ return a + b + c + d; } int main(){ return mix(1, 1.5, 2, 2.5f); }'
+try 211 'int main(){ func_mixed(1, 0.5, 5, 2.0); }'
+try   3 'int main(){ double d[3]; d[1] = 1.25; d[2] = 1.75; return d[1] + d[2]; }'
+try   9 'int main(){ struct { char c; double d; } s; s.d = 9.5; return sizeof(s) + s.d - 16; }'
+try   8 'int add(int a, int b); int main(){ return add(3, 5); } int add(int a, int b){ return a + b; }'
+try 211 'int func_mixed(int a, double b, int c, double d); int main(){ func_mixed(1, 0.5, 5, 2); }'
+try  15 'double func_half(double); int main(){ return func_half(31) * 1; }'
+try   7 'double func_half(double a); int main(){ double x; x = func_half(15); return x * 2 - 8; }'
+try   3 'int f(); int main(){ return f(1, 2); } int f(int a, int b){ return a + b; }'
+try   6 'int sum(int *p, int n); int main(){ int a[3]; a[0] = 1; a[1] = 2; a[2] = 3; return sum(a, 3); } int sum(int *p, int n){ if (n == 0) return 0; return *p + sum(p + 1, n - 1); }'
+try   1 'char f(void); int main(){ return f(); } char f(void){ return 257; }'
+try  10 'int isEven(int n); int isOdd(int n){ if (n == 0) return 0; return isEven(n - 1); } int isEven(int n){ if (n == 0) return 1; return isOdd(n - 1); } int main(){ return isEven(10) * 10; }'
+try   3 '#define A 3
+int main(){ return A; }'
+try   9 '#define SQ(x) ((x) * (x))
+int main(){ return SQ(1 + 2); }'
+try   5 '#define CAT(a, b) a ## b
+int main(){ int xy; CAT(x, y) = 5; return xy; }'
+try   5 '#define XY x ## y
+int main(){ int xy; XY = 5; return xy; }'
+try   6 '#define STR(x) #x
+int main(){ return sizeof(STR(a + b)); }'
+try   3 '#define ADD(...) add(__VA_ARGS__)
+int add(int a, int b){ return a + b; }
+int main(){ return ADD(1, 2); }'
+try   1 '#define a a
+int main(){ int a; a = 1; return a; }'
+try   1 '#if 1 + 1 == 3
+#error unreachable
+#elif defined(FOO) + 1
+int main(){ return 1; }
+#else
+int main(){ return 2; }
+#endif'
+try   4 '#define FOO
+#ifdef FOO
+#ifndef FOO
+#error unreachable
+#else
+int main(){ return 4; }
+#endif
+#endif'
+try   7 '#define FOO
+#undef FOO
+#ifdef FOO
+#error unreachable
+#endif
+int main(){ return 7; }'
+try   2 'int main(){
+  return __LINE__; }'
+try  42 '#line 42
+int main(){ return __LINE__; }'
+try   3 '#if 0
+# include <gnu/stubs-1.2.3.h>
+#endif
+int main(){ return 3; }'
+try   4 '#define V 1.2.3
+#define CAT(a, b) a##b
+int main(){ return CAT(0x, 4); }'
+try   4 '#if __STDC__ && __STDC_VERSION__ >= 201112L && __x86_64__ && __LP64__ && __linux__
+int main(){ return __SIZEOF_POINTER__ / 2; }
+#endif'
+try   5 '#include <limits.h>
+#include <stdint.h>
+int main(){ int64_t a; uint8_t b; a = INT_MAX; b = 255; return (a == 2147483647) + (b == UINT8_MAX) + (CHAR_BIT == 8) + (LONG_MAX == INT64_MAX) + (sizeof(intptr_t) == 8); }'
+try   9 '#include <gnu/stubs.h>
+#include <stddef.h>
+struct S { char a; long b; };
+int main(){ char *p; size_t n; p = NULL; n = sizeof(ptrdiff_t); return (p == 0) + offsetof(struct S, b) + (n == 8) - 1; }'
+try  30 '#include <stdarg.h>
+int sum(int n, ...){ __gnuc_va_list ap; va_start(ap, n); int s; s = va_arg(ap, int) + va_arg(ap, int); va_end(ap); return s; }
+int main(){ return sum(2, 10, 20); }'
+try   8 'int main(){ return sizeof(__FILE__); }'
+
+try_files() {
+  expected="$1"
+  shift
+
+  ./9cc "$@" > tmp.s
+  gcc -static -o tmp tmp.s test/*.o
+  ./tmp
+  actual="$?"
+  rm -f tmp
+
+  if [ "$actual" = "$expected" ]; then
+    echo "$* => $actual"
+  else
+    echo "$* => $expected expected, but got $actual"
+    exit 1
+  fi
+}
+
+try_errors() {
+  expected="$1"
+  input="$2"
+
+  actual=$(echo "$input" | ./9cc - 2>&1 >/dev/null | grep -c ': error: ')
+
+  if [ "$actual" = "$expected" ]; then
+    echo "$input => $actual errors"
+  else
+    echo "$input => $expected errors expected, but got $actual"
+    exit 1
+  fi
+}
+
+try_max_errors() {
+  expected="$1"
+  note="$2"
+  input="$3"
+  shift 3
+
+  err=$(echo "$input" | ./9cc "$@" - 2>&1 >/dev/null)
+  actual=$(echo "$err" | grep -c ': error: ')
+  actual_note=$(echo "$err" | grep 'compilation terminated')
+
+  if [ "$actual" = "$expected" ] && [ "$actual_note" = "$note" ]; then
+    echo "$* => $actual errors"
+  else
+    echo "$* => $expected errors and \"$note\" expected, but got $actual errors and \"$actual_note\""
+    exit 1
+  fi
+}
+
+undefined25="int main(){ $(for i in $(seq 25); do printf 'x%d; ' $i; done)}"
+try_max_errors 20 'compilation terminated due to -fmax-errors=20.' "$undefined25"
+try_max_errors 3 'compilation terminated due to -fmax-errors=3.' "$undefined25" -fmax-errors=3
+try_max_errors 25 '' "$undefined25" -fmax-errors=0
+
+try_errors 1 'int main(){ x; }'
+try_errors 1 'int main(){ x; x = 1; return x; }'
+try_errors 2 'int x[y]; int y;'
+try_errors 2 'int f(){ return x; } int g(){ return x; } int x;'
+try_errors 2 'int main(){ 1 = 2; int *p; p + p; }'
+try_errors 1 'int main(){ return 0; } /* unterminated'
+try_errors 1 'int main(){ return 0x10000000000000000; }'
+try_errors 2 'int main(){ 09 + 1lul; }'
+try_errors 1 '#error stop here'
+try_errors 1 '#include "no_such_file.h"'
+try_errors 1 '#if (*0)++
+#endif'
+try_errors 1 '#if (*0) += 1
+#endif'
+try_errors 1 '#if (1 = 2)
+#endif'
+try_errors 1 '#if 1, 2
+#endif'
+try_errors 1 '#if 1
+int main(){ return 0; }'
+try_errors 4 'int main(){ (1; 2 +; } int f(,) {} int g(){ *1; }'
+try_errors 1 'int main(){ struct { int a; } x; x.b; }'
+try_errors 1 'int main(){ int x; x.a; }'
+try_errors 1 'int main(){ struct S; struct S x; }'
+try_errors 1 'int main(){ struct { int a; int a; } x; }'
+try_errors 1 'int main(){ struct { int a; union { int a; }; } x; }'
+try_errors 1 'int main(){ struct S { int a; }; union S x; }'
+try_errors 1 'int main(){ enum { A, A }; }'
+try_errors 1 'int main(){ int x; int a[x]; }'
+try_errors 1 'int main(){ enum E x; }'
+try_errors 1 'int main(){ enum { A }; A = 1; }'
+try_errors 1 'int main(){ typedef int T; T + 1; }'
+try_errors 1 'int main(){ int char x; }'
+try_errors 1 'int main(){ short char x; }'
+try_errors 1 'int main(){ long long long x; }'
+try_errors 1 'int main(){ float double x; }'
+try_errors 1 'int main(){ 1.5x; }'
+try_errors 1 '#define V 1.2.3
+int main(){ return V; }'
+try_errors 1 'int add(int a, int b); int main(){ return add(1); }'
+try_errors 1 'int add(int a, int b); int main(){ return add(1, 2, 3); }'
+try_errors 1 'int f(void); int main(){ return f(1); }'
+try_errors 1 'int f(int a); int f(char *a); int main(){ 0; }'
+try_errors 1 'int f(int a); double f(int a); int main(){ 0; }'
+try_errors 1 'int f(){ return 0; } int f(){ return 1; } int main(){ 0; }'
+try_errors 1 'int f(int); int f(int){ return 0; } int main(){ 0; }'
+try_errors 1 'int x; int main(){ return x(); }'
+try_errors 1 'int f; int f(){ return 1; }'
+try_errors 1 'typedef int f; void f(){ return; }'
+try_errors 1 'struct S { int a; }; int f(int a); int main(){ struct S s; return f(s); }'
+try_errors 1 'struct S { int a; int b; }; int f(struct S s){ return s.b; }'
+try_errors 1 'struct S { int a; }; int f(); int main(){ struct S s; return f(s); }'
+try_errors 1 'union U { int a; }; int printf(char *fmt, ...); int main(){ union U u; printf("", u); }'
+try_errors 1 'struct S { int a; }; struct S f(){ struct S s; return s; }'
+try_errors 1 'int f(double *a); int main(){ return f(1.5); }'
+try_errors 1 'int main(){ int *p; long l; l = 1; p = l; }'
+try_errors 1 'int main(){ int *p; p = 1; }'
+try_errors 1 'int main(){ int *p; char *q; p = q; }'
+try_errors 1 'int f(int *p); int main(){ char c; return f(&c); }'
+try_errors 1 'int f(int *p); int main(){ return f(2); }'
+try_errors 1 'int *f(){ long l; return &l; }'
+try_errors 1 'char *f(){ return 1; }'
+try_errors 1 'int f(){ int *p; return p + p; }'
+try_errors 1 'int f(){ struct { int a; } s; return s.b; }'
+try_errors 1 'int f(){ struct { int a; } s; s.b = 1; return 0; }'
+try_errors 2 'int f(){ int x; x.a = 1; return x.a; }'
+try_errors 0 'int *f(){ return 0; } int main(){ int *p; void *v; char *q; p = 0; p = 0L; v = p; q = v; p = (int *)q; return f(0) == 0; }'
+try_errors 1 'int printf(char *fmt, ...); int main(){ printf(); }'
+try_errors 1 'int f(int n){ va_list ap; va_start(ap, n); return 0; }'
+try_errors 1 'int f(int n, ...){ int x; va_start(x, n); return 0; }'
+try_errors 1 'int f(int n, ...){ va_list ap; va_start(ap, n); return va_arg(ap, float); }'
+try_errors 1 'int f(int a, ...); int f(int a); int main(){ 0; }'
+try_errors 1 'int main(){ double d; d % 2; }'
+try_errors 1 'int main(){ int *p; p & 1; }'
+try_errors 1 'int main(){ double d; d << 1; }'
+try_errors 1 'int main(){ double d; ~d; }'
+try_errors 1 'int main(){ int *p; -p; }'
+try_errors 1 'int main(){ int *p; double d; 1 ? p : d; }'
+try_errors 1 'int main(){ int *p; char *q; 1 ? p : q; }'
+try_errors 1 'void f(){} int main(){ 1 ? f() : 2; }'
+try_errors 1 'int main(){ int x; (1 ? x : x) = 1; }'
+try_errors 1 'int main(){ 1 += 2; }'
+try_errors 1 'int main(){ int a[2]; a++; }'
+try_errors 1 'int main(){ 3++; }'
+try_errors 1 'struct S { int a; }; int main(){ struct S s; (int)s; }'
+try_errors 1 'struct S { int a; }; int main(){ int x; (struct S)x; }'
+try_errors 1 'int main(){ int *p; (double)p; }'
+try_errors 1 'int main(){ double d; (int *)d; }'
+try_errors 1 'void f(){} int main(){ (int)f(); }'
+try_errors 1 'int main(){ struct S { int a; }; unsigned struct S x; }'
+try_errors 1 'void f(){} int main(){ return f() + 1; }'
+try_errors 1 'void f(){} int main(){ int x; x = f(); }'
+try_errors 1 'void f(){ return 1; } int main(){ 0; }'
+try_errors 1 'int f(){ return; } int main(){ 0; }'
+try_errors 1 'int main(){ void x; }'
+try_errors 1 'int main(){ sizeof(void); }'
+try_errors 1 'struct S *p; int main(){ return sizeof(*p); }'
+try_errors 1 'struct S *p; int main(){ return sizeof p[0]; }'
+try_errors 1 'int main(){ void *p; *p; }'
+try_errors 1 'void f(){} int main(){ if (f()) 1; }'
+try_errors 1 'int main(){ sizeof(typedef int); }'
+
+# Input which ends without a newline
+try_errors_noeol() {
+  expected="$1"
+  input="$2"
+
+  actual=$(printf '%s' "$input" | ./9cc - 2>&1 >/dev/null | grep -c ': error: ')
+
+  if [ "$actual" = "$expected" ]; then
+    echo "$input => $actual errors"
+  else
+    echo "$input => $expected errors expected, but got $actual"
+    exit 1
+  fi
+}
+
+try_errors_noeol 3 'int main(){ "\'
+try_errors_noeol 3 "int main(){ '\\"
+
+try_diagnostics() {
+  expected="$1"
+  input="$2"
+
+  echo "$input" > tmp_err.c
+  actual=$(./9cc tmp_err.c 2>&1 >/dev/null)
+
+  if [ "$actual" = "$expected" ]; then
+    echo "$input => $actual"
+  else
+    echo "$input => $expected expected, but got $actual"
+    exit 1
+  fi
+}
+
+try_diagnostics 'tmp_err.c:1:19: error: Next token is not ";"
+int main(){ 1 + 2 }
+                  ^' 'int main(){ 1 + 2 }'
+try_diagnostics 'tmp_err.c:2:3: error: Variable "foo" is not defined
+  foo = 1;
+  ^~~' 'int main(){
+  foo = 1;
+  return 0;
+}'
+try_diagnostics "tmp_err.c:2:9: error: Variable \"x\" is not defined
+$(printf '\treturn x;')
+$(printf '\t       ^')" "$(printf 'int main(){\n\treturn x;\n}')"
+try_diagnostics 'tmp_err.c:1:13: error: Unterminated string literal
+int main(){ "abc
+            ^
+tmp_err.c:2:1: error: Next token is not ";"
+}
+^' 'int main(){ "abc
+}'
+try_diagnostics 'tmp_err.c:2:10: error: Integer literal is too large
+  return 0x10000000000000000;
+         ^~~~~~~~~~~~~~~~~~~' 'int main(){
+  return 0x10000000000000000;
+}'
+try_diagnostics 'tmp_err.c:3:15: error: Variable "y" is not defined
+  x = 1 + 2 * y;
+              ^' 'int main(){
+  int x;
+  x = 1 + 2 * y;
+}'
+try_diagnostics 'tmp_err.c:3:7: error: Variable "a" is already defined
+  int a;
+      ^' 'int main(){
+  int a;
+  int a;
+}'
+try_diagnostics 'tmp_err.c:2:5: error: Variable "g" is already defined
+int g;
+    ^' 'int g;
+int g;'
+try_diagnostics 'tmp_err.c:3:17: error: Can not add pointer type value to pointer type value
+  return 1 + (p + p);
+                ^' 'int main(){
+  int *p;
+  return 1 + (p + p);
+}'
+try_diagnostics 'tmp_err.c:3:12: error: Operands of binary % should be integer type
+  return d % 2;
+           ^' 'int main(){
+  double d;
+  return d % 2;
+}'
+try_diagnostics 'tmp_err.c:2:10: error: Operand of unary * should be pointer type
+  return *1;
+         ^' 'int main(){
+  return *1;
+}'
+try_diagnostics 'tmp_err.c:3:12: error: No member named "b"
+  return s.b;
+           ^' 'int main(){
+  struct { int a; } s;
+  return s.b;
+}'
+rm -f tmp_err.c
+
+try_preprocess() {
+  expected="$1"
+  input="$2"
+
+  actual=$(echo "$input" | ./9cc -E -)
+
+  if [ "$actual" = "$expected" ]; then
+    echo "$input => $actual"
+  else
+    echo "$input => $expected expected, but got $actual"
+    exit 1
+  fi
+}
+
+try_preprocess '# 1 "<stdin>"
+int main(){ return 1; }' 'int main(){ return 1; }'
+try_preprocess '# 2 "<stdin>"
+int main(){ return 1 + +1; }' '#define P +
+int main(){ return 1 P+1; }'
+try_preprocess '# 3 "<stdin>"
+int main(){
+  return "1 + 2" "1 + 2";
+}' '#define STR(x) #x
+#define F(x) STR(x) #x
+int main(){
+  return F(1 + 2);
+}'
+try_preprocess '# 6 "<stdin>"
+char p[] = "x ## y";' '#define hash_hash # ## #
+#define mkstr(a) # a
+#define in_between(a) mkstr(a)
+#define join(c, d) in_between(c hash_hash d)
+
+char p[] = join(x, y);'
+try_preprocess '# 1 "<stdin>"
+int a;
+# 20 "foo.c"
+int b;' 'int a;
+#line 20 "foo.c"
+int b;'
+
+echo 'int add(int a, int b){ a + b; }' > tmp_add.c
+echo 'int main(){ add(3, 4); }' > tmp_main.c
+try_files 7 tmp_add.c tmp_main.c
+rm -f tmp_add.c tmp_main.c
+
+mkdir -p tmp_include
+echo '#define ADD(a, b) ((a) + (b))' > tmp_include/add.h
+echo '#include "add.h"' > tmp_main.c
+echo 'int main(){ ADD(3, 4); }' >> tmp_main.c
+try_files 7 -I tmp_include tmp_main.c
+try_files 7 -Itmp_include tmp_main.c
+rm -rf tmp_include tmp_main.c
+
+# Each file is a translation unit with its own scope.
+mkdir -p tmp_include
+echo 'typedef struct Point { int x; int y; } Point;' > tmp_include/point.h
+echo '#include "point.h"' > tmp_getx.c
+echo 'int getx(Point *p){ return p->x; }' >> tmp_getx.c
+echo '#include "point.h"' > tmp_main.c
+echo 'int getx(Point *p); int main(){ Point p; p.x = 5; return getx(&p); }' >> tmp_main.c
+try_files 5 -I tmp_include tmp_getx.c tmp_main.c
+rm -rf tmp_include tmp_getx.c tmp_main.c
+
+try_errors_files() {
+  expected="$1"
+  shift
+
+  actual=$(./9cc "$@" 2>&1 >/dev/null | grep -c ': error: ')
+
+  if [ "$actual" = "$expected" ]; then
+    echo "$* => $actual errors"
+  else
+    echo "$* => $expected errors expected, but got $actual"
+    exit 1
+  fi
+}
+
+echo 'int x; int f(){ return 1; } int g();' > tmp_a.c
+echo 'int x; int f(){ return 2; } int g(); int main(){ return 0; }' > tmp_b.c
+try_errors_files 2 tmp_a.c tmp_b.c
+rm -f tmp_a.c tmp_b.c
 
 echo OK
@@ -34,3 +34,33 @@ int func6(int a, int b, int c, int d, int e, int f) {
     printf("%s(%d, %d, %d, %d, %d, %d) called\n", __func__, a, b, c, d, e, f);
     return a + b + c + d + e + f + 1;
 }
+
+int func7(int a, int b, int c, int d, int e, int f, int g) {
+    printf("%s(%d, %d, %d, %d, %d, %d, %d) called\n", __func__, a, b, c, d, e, f, g);
+    return a + b + c + d + e + f + g + 1;
+}
+
+int func8(int a, int b, int c, int d, int e, int f, int g, int h) {
+    printf("%s(%d, %d, %d, %d, %d, %d, %d, %d) called\n", __func__, a, b, c, d, e, f, g, h);
+    return a + b + c + d + e + f + g + h + 1;
+}
+
+int func9(int a, int b, int c, int d, int e, int f, int g, int h, int i) {
+    printf("%s(%d, %d, %d, %d, %d, %d, %d, %d, %d) called\n", __func__, a, b, c, d, e, f, g, h, i);
+    return a + b + c + d + e + f + g + h + i + 1;
+}
+
+int func10(int a, int b, int c, int d, int e, int f, int g, int h, int i, int j) {
+    printf("%s(%d, %d, %d, %d, %d, %d, %d, %d, %d, %d) called\n", __func__, a, b, c, d, e, f, g, h, i, j);
+    return a + b + c + d + e + f + g + h + i + j + 1;
+}
+
+int func_mixed(int a, double b, int c, double d) {
+    printf("%s(%d, %g, %d, %g) called\n", __func__, a, b, c, d);
+    return a + b * 10 + c + d * 100;
+}
+
+double func_half(double a) {
+    printf("%s(%g) called\n", __func__, a);
+    return a / 2;
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -13,7 +17,8 @@ type TokenKind int
 const (
 	tkReserved TokenKind = iota // Reserved word or symbol
 	tkIdent                     // Identifier
-	tkNum                       // Integer
+	tkNum                       // Integer or floating point number
+	tkStr                       // String literal
 	tkEOF                       // End of input
 )
 
@@ -21,8 +26,80 @@ type Token struct {
 	kind TokenKind
 	next *Token
 	str  []rune
-	pos  int
-	val  int // Valid only if kind is tkNum
+	pos  Pos
+	val  int     // Valid only if kind is tkNum and typ is an integer type
+	fval float64 // Valid only if kind is tkNum and typ is a floating type
+	typ  *Type   // Valid only if kind is tkNum
+
+	// Valid only if kind is tkStr. Escape sequences are already decoded
+	// and the terminating NUL is not included.
+	contents []byte
+
+	// Used by the preprocessor
+	atBol    bool     // The first token in a line
+	hasSpace bool     // Preceded by a space
+	hideset  *Hideset // Macros which must not be expanded for this token
+	origin   *Token   // Macro invocation which this token is expanded from
+}
+
+// File is a source file given to the compiler.
+type File struct {
+	name       string // Name for diagnostics, which can be changed by #line
+	path       string // Path to read the file
+	contents   []rune
+	lineStarts []int // Offset of the first character of each line
+	lineDelta  int   // Difference of line numbers specified by #line
+	depth      int   // Nesting level of #include
+}
+
+// Pos is a location in a source file.
+type Pos struct {
+	file   *File
+	offset int // Offset in file.contents
+	line   int // 1-origin line number
+	col    int // 1-origin column number
+}
+
+func newFile(name string, contents []rune) *File {
+	lineStarts := []int{0}
+	for i, r := range contents {
+		if r == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	return &File{
+		name:       name,
+		path:       name,
+		contents:   contents,
+		lineStarts: lineStarts,
+	}
+}
+
+func (f *File) pos(offset int) Pos {
+	line := sort.Search(len(f.lineStarts), func(i int) bool {
+		return f.lineStarts[i] > offset
+	})
+	return Pos{
+		file:   f,
+		offset: offset,
+		line:   line,
+		col:    offset - f.lineStarts[line-1] + 1,
+	}
+}
+
+// lineNo returns the line number of pos taking #line into account.
+func (p Pos) lineNo() int {
+	return p.line + p.file.lineDelta
+}
+
+// lineText returns the contents of the given line without newline.
+func (f *File) lineText(line int) []rune {
+	start := f.lineStarts[line-1]
+	end := start
+	for end < len(f.contents) && f.contents[end] != '\n' {
+		end++
+	}
+	return f.contents[start:end]
 }
 
 var token *Token
@@ -52,7 +129,7 @@ func expect(op string) {
 	if token.kind == tkReserved && reflect.DeepEqual(token.str, []rune(op)) {
 		token = token.next
 	} else {
-		fatalAt(token.pos, "Next token is not \"%s\"", op)
+		syntaxErrorTok(token, "Next token is not \"%s\"", op)
 	}
 }
 
@@ -62,13 +139,13 @@ func expectKind(kind TokenKind) *Token {
 		token = token.next
 		return expected
 	}
-	fatalAt(token.pos, "Unexpected next token")
+	syntaxErrorTok(token, "Unexpected next token")
 	return nil
 }
 
 func expectNumber() int {
 	if token.kind != tkNum {
-		fatalAt(token.pos, "Next token is not number")
+		syntaxErrorTok(token, "Next token is not number")
 	}
 	val := token.val
 	token = token.next
@@ -79,118 +156,399 @@ func atEOF() bool {
 	return token.kind == tkEOF
 }
 
-func newToken(kind TokenKind, cur *Token, str []rune, pos int) *Token {
+// readFile reads a source file. "-" means standard input.
+func readFile(path string) *File {
+	var bytes []byte
+	var err error
+	if path == "-" {
+		path = "<stdin>"
+		bytes, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		bytes, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		fatal("Unable to read %s: %v", path, err)
+	}
+	return newFile(path, []rune(string(bytes)))
+}
+
+// Flags given to the next token created by newToken
+var nextAtBol, nextHasSpace bool
+
+func newToken(kind TokenKind, cur *Token, str []rune, pos Pos) *Token {
 	tok := &Token{
-		kind: kind,
-		pos:  pos,
-		str:  str,
+		kind:     kind,
+		pos:      pos,
+		str:      str,
+		atBol:    nextAtBol,
+		hasSpace: nextHasSpace,
 	}
+	nextAtBol, nextHasSpace = false, false
 	cur.next = tok
 	return tok
 }
 
-func tokenize(p []rune) *Token {
+func tokenize(file *File) *Token {
 	head := Token{next: nil}
 	cur := &head
+	p := file.contents
+	nextAtBol, nextHasSpace = true, false
 
 	for pos, length := 0, len(p); pos < length; {
+		// Line continuation
+		if startsWith(p, pos, "\\\n") {
+			pos += 2
+			nextHasSpace = true
+			continue
+		}
+
 		// Space
 		if unicode.IsSpace(p[pos]) {
+			if p[pos] == '\n' {
+				nextAtBol = true
+			}
+			nextHasSpace = true
 			pos++
 			continue
 		}
 
-		// 1 or 2 character symbol
-		l := isReservedSymbol(p, pos)
-		if l > 0 {
-			cur = newToken(tkReserved, cur, p[pos:pos+l], pos)
+		// Line comment
+		if startsWith(p, pos, "//") {
+			for pos < length && p[pos] != '\n' {
+				pos++
+			}
+			nextHasSpace = true
+			continue
+		}
+
+		// Block comment
+		if startsWith(p, pos, "/*") {
+			end := pos + 2
+			for end < length && !startsWith(p, end, "*/") {
+				end++
+			}
+			if end >= length {
+				errorAt(file.pos(pos), "Unterminated comment")
+				pos = length
+				continue
+			}
+			pos = end + 2
+			nextHasSpace = true
+			continue
+		}
+
+		// String literal
+		if p[pos] == '"' {
+			l, contents := readStringLiteral(file, pos)
+			cur = newToken(tkStr, cur, p[pos:pos+l], file.pos(pos))
+			cur.contents = contents
 			pos += l
 			continue
 		}
 
-		// Reserved word (e.g. "if")
-		l = isReservedWord(p, pos)
-		if l > 0 {
-			cur = newToken(tkReserved, cur, p[pos:pos+l], pos)
+		// Character literal
+		if p[pos] == '\'' {
+			l, val := readCharLiteral(file, pos)
+			cur = newToken(tkNum, cur, p[pos:pos+l], file.pos(pos))
+			cur.val = val
+			cur.typ = typeInt
 			pos += l
 			continue
 		}
 
-		// Variable name
-		l = isIdent(p, pos)
+		// Preprocessing number, which is converted by convertNumbers after
+		// preprocessing
+		if isDigit(p[pos]) || (p[pos] == '.' && pos+1 < len(p) && isDigit(p[pos+1])) {
+			l := ppNumberLen(p, pos)
+			cur = newToken(tkNum, cur, p[pos:pos+l], file.pos(pos))
+			pos += l
+			continue
+		}
+
+		// Symbol
+		l := isReservedSymbol(p, pos)
 		if l > 0 {
-			cur = newToken(tkIdent, cur, p[pos:pos+l], pos)
+			cur = newToken(tkReserved, cur, p[pos:pos+l], file.pos(pos))
 			pos += l
 			continue
 		}
 
-		// Number
-		l = isNumber(p, pos)
+		// Identifier including reserved words, which are converted by
+		// convertKeywords after preprocessing
+		l = isIdent(p, pos)
 		if l > 0 {
-			str := p[pos : pos+l]
-			num, err := strconv.Atoi(string(str))
-			if err != nil {
-				fatalAt(pos, "Expect number")
-			}
-			cur = newToken(tkNum, cur, str, pos)
-			cur.val = num
+			cur = newToken(tkIdent, cur, p[pos:pos+l], file.pos(pos))
 			pos += l
 			continue
 		}
 
-		fatalAt(pos, "Unable to tokenize")
+		errorAt(file.pos(pos), "Unable to tokenize")
+		pos++
 	}
 
-	newToken(tkEOF, cur, []rune{}, len(p))
+	newToken(tkEOF, cur, []rune{}, file.pos(len(p)))
+	return head.next
+}
+
+// joinTokens concatenates token lists of multiple files into one list
+// terminated by the EOF token of the last file.
+func joinTokens(lists []*Token) *Token {
+	head := Token{next: nil}
+	cur := &head
+	for _, tok := range lists {
+		cur.next = tok
+		for cur.next.kind != tkEOF {
+			cur = cur.next
+		}
+	}
 	return head.next
 }
 
+// readStringLiteral reads a string literal starting at p[start] and returns
+// its length in the source and the decoded contents.
+func readStringLiteral(file *File, start int) (int, []byte) {
+	p := file.contents
+	var buf []byte
+	pos := start + 1
+	for {
+		if pos >= len(p) || p[pos] == '\n' {
+			errorAt(file.pos(start), "Unterminated string literal")
+			return pos - start, buf
+		}
+		if p[pos] == '"' {
+			return pos + 1 - start, buf
+		}
+		if p[pos] != '\\' {
+			buf = append(buf, string(p[pos])...)
+			pos++
+			continue
+		}
+
+		c, l := readEscape(file, pos)
+		if isUnicodeEscape(p, pos) {
+			buf = append(buf, string(rune(c))...)
+		} else {
+			buf = append(buf, byte(c))
+		}
+		pos += l
+	}
+}
+
+// readCharLiteral reads a character literal starting at p[start] and returns
+// its length in the source and its value as int.
+func readCharLiteral(file *File, start int) (int, int) {
+	p := file.contents
+	pos := start + 1
+	if pos >= len(p) || p[pos] == '\n' || p[pos] == '\'' {
+		errorAt(file.pos(start), "Empty character literal")
+		return pos - start, 0
+	}
+
+	var val int
+	if p[pos] == '\\' {
+		c, l := readEscape(file, pos)
+		if isUnicodeEscape(p, pos) {
+			val = c
+		} else {
+			// char is signed, so '\377' is -1 as int.
+			val = int(int8(c))
+		}
+		pos += l
+	} else {
+		val = int(p[pos])
+		pos++
+	}
+
+	if pos >= len(p) || p[pos] != '\'' {
+		errorAt(file.pos(start), "Unterminated character literal")
+		for pos < len(p) && p[pos] != '\n' && p[pos] != '\'' {
+			pos++
+		}
+		if pos < len(p) && p[pos] == '\'' {
+			pos++
+		}
+		return pos - start, val
+	}
+	return pos + 1 - start, val
+}
+
+// isUnicodeEscape reports whether the escape sequence at p[pos] is a
+// universal character name, which is encoded in UTF-8.
+func isUnicodeEscape(p []rune, pos int) bool {
+	return pos+1 < len(p) && (p[pos+1] == 'u' || p[pos+1] == 'U')
+}
+
+// readEscape reads an escape sequence starting with the backslash at p[pos]
+// and returns its value and length in the source.
+func readEscape(file *File, pos int) (int, int) {
+	p := file.contents
+	if pos+1 >= len(p) {
+		errorAt(file.pos(pos), "Incomplete escape sequence")
+		return 0, 1
+	}
+
+	// Octal escape sequence (e.g. "\0", "\177")
+	if isOctalDigit(p[pos+1]) {
+		c, l := 0, 1
+		for l <= 3 && pos+l < len(p) && isOctalDigit(p[pos+l]) {
+			c = c*8 + int(p[pos+l]-'0')
+			l++
+		}
+		if c > 0xff {
+			errorAt(file.pos(pos), "Octal escape sequence out of range")
+		}
+		return c, l
+	}
+
+	// Hexadecimal escape sequence (e.g. "\x41") and universal character
+	// name (e.g. "\u00e9")
+	var digits int
+	switch p[pos+1] {
+	case 'x':
+		digits = -1
+	case 'u':
+		digits = 4
+	case 'U':
+		digits = 8
+	}
+	if digits != 0 {
+		c, l := 0, 2
+		for (digits < 0 || l < digits+2) && pos+l < len(p) && isHexDigit(p[pos+l]) {
+			c = c*16 + hexValue(p[pos+l])
+			l++
+		}
+		if l == 2 || (digits > 0 && l != digits+2) {
+			errorAt(file.pos(pos), "Invalid escape sequence")
+		} else if digits < 0 && c > 0xff {
+			errorAt(file.pos(pos), "Hex escape sequence out of range")
+		}
+		return c, l
+	}
+
+	switch p[pos+1] {
+	case 'a':
+		return '\a', 2
+	case 'b':
+		return '\b', 2
+	case 'f':
+		return '\f', 2
+	case 'n':
+		return '\n', 2
+	case 'r':
+		return '\r', 2
+	case 't':
+		return '\t', 2
+	case 'v':
+		return '\v', 2
+	case 'e':
+		// GNU extension
+		return 27, 2
+	}
+	// "\\", "\'", "\"", "\?" and unknown escape sequences
+	return int(p[pos+1]), 2
+}
+
+func isOctalDigit(r rune) bool {
+	return '0' <= r && r <= '7'
+}
+
+func isHexDigit(r rune) bool {
+	return ('0' <= r && r <= '9') || ('a' <= r && r <= 'f') || ('A' <= r && r <= 'F')
+}
+
+func hexValue(r rune) int {
+	switch {
+	case '0' <= r && r <= '9':
+		return int(r - '0')
+	case 'a' <= r && r <= 'f':
+		return int(r - 'a' + 10)
+	default:
+		return int(r - 'A' + 10)
+	}
+}
+
+func startsWith(p []rune, pos int, prefix string) bool {
+	runes := []rune(prefix)
+	return len(p)-pos >= len(runes) && reflect.DeepEqual(p[pos:pos+len(runes)], runes)
+}
+
 func isReservedSymbol(p []rune, pos int) int {
 	remain := len(p) - pos
 
+	if remain >= 3 {
+		switch string(p[pos : pos+3]) {
+		case "...", "<<=", ">>=":
+			return 3
+		}
+	}
+
 	if remain >= 2 {
 		switch string(p[pos : pos+2]) {
-		case "<=", ">=", "==", "!=":
+		case "<=", ">=", "==", "!=", "->", "&&", "||", "<<", ">>", "##",
+			"++", "--", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=":
 			return 2
 		}
 	}
 
 	switch p[pos] {
-	case '+', '-', '*', '/', '&', '(', ')', '<', '>', '=', '{', '}', '[', ']', ';', ',':
+	case '+', '-', '*', '/', '%', '&', '|', '^', '~', '!', '(', ')', '<', '>', '=', '{', '}', '[', ']', ';', ',', '.', '?', ':', '#':
 		return 1
 	}
 
 	return 0
 }
 
-func isReservedWord(p []rune, pos int) int {
-	words := []string{
-		"int",
-		"char",
-		"if",
-		"else",
-		"while",
-		"for",
-		"return",
-		"sizeof",
-	}
+var keywords = []string{
+	"int",
+	"char",
+	"if",
+	"else",
+	"while",
+	"for",
+	"return",
+	"sizeof",
+	"struct",
+	"union",
+	"enum",
+	"typedef",
+	"short",
+	"long",
+	"signed",
+	"unsigned",
+	"_Bool",
+	"void",
+	"float",
+	"double",
+}
 
-	remain := len(p) - pos
-	for _, word := range words {
-		runes := []rune(word)
-		l := len(runes)
-		if l > remain {
+// convertKeywords marks identifiers which are reserved words as tkReserved.
+func convertKeywords(tok *Token) {
+	for t := tok; t != nil; t = t.next {
+		if t.kind != tkIdent {
 			continue
 		}
-		if !reflect.DeepEqual(p[pos:pos+l], runes) {
-			continue
+		for _, word := range keywords {
+			if string(t.str) == word {
+				t.kind = tkReserved
+				break
+			}
 		}
-		if l < remain && isTokenChar(p[pos+l]) {
+	}
+}
+
+// convertNumbers reads the values of preprocessing numbers, which are
+// valid only as integer or floating literals.
+func convertNumbers(tok *Token) {
+	for t := tok; t != nil; t = t.next {
+		if t.kind != tkNum || t.typ != nil {
 			continue
 		}
-		return l
+		if isFloatLiteral(string(t.str)) {
+			t.fval, t.typ = readFloatLiteral(t)
+		} else {
+			t.val, t.typ = readIntLiteral(t)
+		}
 	}
-	return 0
 }
 
 func isIdent(p []rune, pos int) int {
@@ -205,12 +563,138 @@ func isIdent(p []rune, pos int) int {
 	return end - pos
 }
 
-func isNumber(p []rune, pos int) int {
-	end := pos
-	for end < len(p) && unicode.IsDigit(p[end]) {
-		end++
+// ppNumberLen returns the length of a preprocessing number, which covers
+// both integer and floating literals like "1.5e+3f".
+func ppNumberLen(p []rune, start int) int {
+	end := start
+	for end < len(p) {
+		if end+1 < len(p) && strings.ContainsRune("eEpP", p[end]) && (p[end+1] == '+' || p[end+1] == '-') {
+			end += 2
+		} else if isTokenChar(p[end]) || p[end] == '.' {
+			end++
+		} else {
+			break
+		}
 	}
-	return end - pos
+	return end - start
+}
+
+func isFloatLiteral(str string) bool {
+	lower := strings.ToLower(str)
+	if strings.HasPrefix(lower, "0x") {
+		return strings.ContainsAny(lower, ".p")
+	}
+	return strings.ContainsAny(lower, ".e")
+}
+
+// readFloatLiteral reads a floating literal and returns its value and type.
+func readFloatLiteral(tok *Token) (float64, *Type) {
+	str := string(tok.str)
+	typ := typeDouble
+	switch str[len(str)-1] {
+	case 'f', 'F':
+		typ = typeFloat
+		str = str[:len(str)-1]
+	case 'l', 'L':
+		// long double is treated as double.
+		str = str[:len(str)-1]
+	}
+
+	val, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			errorTok(tok, "Floating literal is too large")
+		} else {
+			errorTok(tok, "Invalid floating literal")
+		}
+		return 0, typ
+	}
+	if typ == typeFloat {
+		val = float64(float32(val))
+	}
+	return val, typ
+}
+
+// readIntLiteral reads an integer literal and returns its value and type.
+func readIntLiteral(tok *Token) (int, *Type) {
+	str := string(tok.str)
+	lower := strings.ToLower(str)
+
+	base, digits := 10, str
+	if strings.HasPrefix(lower, "0x") {
+		base, digits = 16, str[2:]
+	} else if strings.HasPrefix(lower, "0b") {
+		base, digits = 2, str[2:]
+	} else if str[0] == '0' {
+		base = 8
+	}
+
+	n := 0
+	for n < len(digits) && (isDigit(rune(digits[n])) || (base == 16 && isHexDigit(rune(digits[n])))) {
+		n++
+	}
+	suffix := strings.ToLower(digits[n:])
+	if !isIntSuffix(digits[n:]) {
+		errorTok(tok, "Invalid suffix \"%s\" on integer literal", digits[n:])
+		return 0, typeInt
+	}
+
+	uval, err := strconv.ParseUint(digits[:n], base, 64)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			errorTok(tok, "Integer literal is too large")
+		} else {
+			errorTok(tok, "Invalid integer literal")
+		}
+		return 0, typeInt
+	}
+
+	// Choose the first type that can represent the value.
+	isUnsigned := strings.Contains(suffix, "u")
+	isLong := strings.Contains(suffix, "l")
+	var typ *Type
+	switch {
+	case isUnsigned && isLong:
+		typ = typeULong
+	case isUnsigned:
+		if uval <= math.MaxUint32 {
+			typ = typeUInt
+		} else {
+			typ = typeULong
+		}
+	case isLong:
+		if uval <= math.MaxInt64 {
+			typ = typeLong
+		} else {
+			typ = typeULong
+		}
+	default:
+		if uval <= math.MaxInt32 {
+			typ = typeInt
+		} else if base != 10 && uval <= math.MaxUint32 {
+			typ = typeUInt
+		} else if uval <= math.MaxInt64 {
+			typ = typeLong
+		} else {
+			typ = typeULong
+		}
+	}
+	return int(uval), typ
+}
+
+func isIntSuffix(s string) bool {
+	switch s {
+	case "", "u", "U",
+		"l", "L", "ll", "LL",
+		"ul", "uL", "Ul", "UL", "lu", "lU", "Lu", "LU",
+		"ull", "uLL", "Ull", "ULL", "llu", "llU", "LLu", "LLU":
+		return true
+	}
+	return false
+}
+
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
 }
 
 func isTokenFirstChar(r rune) bool {
@@ -221,9 +705,48 @@ func isTokenChar(r rune) bool {
 	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
 }
 
-func printTokens() {
-	for t := token; t != nil; t = t.next {
-		fmt.Fprintf(os.Stderr, "token: kind=%d, str=\"%s\", pos=%d, val=%d\n",
-			t.kind, string(t.str), t.pos, t.val)
+// printTokens prints tokens as C source text with line markers like
+// "# 10 "foo.c"" in the same way as "gcc -E".
+func printTokens(tok *Token) {
+	fileName := ""
+	line := 0
+	var prev *Token
+	for t := tok; t.kind != tkEOF; t = t.next {
+		// Tokens expanded from a macro are located at the invocation.
+		pos := t.pos
+		for o := t.origin; o != nil; o = o.origin {
+			pos = o.pos
+		}
+
+		if prev == nil || pos.file.name != fileName || pos.lineNo() < line || pos.lineNo() > line+8 {
+			if prev != nil {
+				fmt.Printf("\n")
+			}
+			fileName = pos.file.name
+			line = pos.lineNo()
+			fmt.Printf("# %d \"%s\"\n", line, fileName)
+			fmt.Printf("%s", strings.Repeat(" ", pos.col-1))
+		} else if pos.lineNo() > line {
+			fmt.Printf("%s", strings.Repeat("\n", pos.lineNo()-line))
+			fmt.Printf("%s", strings.Repeat(" ", pos.col-1))
+			line = pos.lineNo()
+		} else if t.hasSpace || needsSpace(prev, t) {
+			fmt.Printf(" ")
+		}
+
+		fmt.Printf("%s", string(t.str))
+		prev = t
+	}
+	fmt.Printf("\n")
+}
+
+// needsSpace reports whether two adjacent tokens are printed with a space
+// to keep them from being read as a single token.
+func needsSpace(prev *Token, tok *Token) bool {
+	last := prev.str[len(prev.str)-1]
+	first := tok.str[0]
+	if isTokenChar(last) && isTokenChar(first) {
+		return true
 	}
+	return strings.ContainsRune("+-*/<>=&|!#.%^", last) && strings.ContainsRune("+-*/<>=&|!#.%^", first)
 }